@@ -0,0 +1,187 @@
+package govcr
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// MatchWeights are the weights of the parts of a request in the score of VCRConfig.BestMatch.
+// A part with a zero weight does not take part in the score.
+type MatchWeights struct {
+	Method float64
+
+	// Host is the weight of the scheme and host of the URL. It is ignored with
+	// VCRConfig.MatchPathOnly and by ServerHandler.
+	Host float64
+
+	// Path is the weight of the path of the URL, scored by the share of its segments that agree.
+	Path float64
+
+	// Query is the weight of the query of the URL, scored by the share of its parameters that agree.
+	Query float64
+
+	// Header is the weight of the header, scored by the share of its (non-excluded) keys whose
+	// values agree.
+	Header float64
+
+	// Body is the weight of the body, which agrees or not as per the options of exact matching.
+	// It is ignored when VCRConfig.ShouldMatchBody rules the body out.
+	Body float64
+}
+
+// defaultMatchWeights are the weights of VCRConfig.BestMatch when VCRConfig.BestMatchWeights is
+// not set.
+var defaultMatchWeights = MatchWeights{Method: 2, Host: 1, Path: 2, Query: 1, Header: 1, Body: 1}
+
+// defaultBestMatchThreshold is the default of VCRConfig.BestMatchThreshold.
+const defaultBestMatchThreshold = 0.75
+
+// bestMatchTrack returns the track of the cassette, not yet replayed, whose request scores
+// best against req as per VCRConfig.BestMatchWeights, with its score. trackNotFound is returned
+// when no score reaches VCRConfig.BestMatchThreshold. Of the tracks with the same score, the
+// first one wins.
+func (pcbr *pcb) bestMatchTrack(cassette *Cassette, req *http.Request, ignoreHost bool) (int, float64) {
+	bodyData, err := readRequestBody(req)
+	if err != nil {
+		pcbr.Logger.Println(err)
+		return trackNotFound, 0
+	}
+
+	weights := pcbr.BestMatchWeights
+	if weights == (MatchWeights{}) {
+		weights = defaultMatchWeights
+	}
+	if ignoreHost || pcbr.MatchPathOnly {
+		weights.Host = 0
+	}
+	if !pcbr.shouldMatchBody(req) {
+		weights.Body = 0
+	}
+
+	threshold := pcbr.BestMatchThreshold
+	if threshold <= 0 {
+		threshold = defaultBestMatchThreshold
+	}
+
+	filteredReqHeader, filteredReqBody := pcbr.RequestFilterFunc(req.Header, bodyData)
+	reqHeader := pcbr.normaliseHeader(*filteredReqHeader)
+	reqBody := pcbr.canonicalBody(*filteredReqHeader, *filteredReqBody)
+	reqURL := pcbr.scoredURL(req.URL, ignoreHost)
+
+	key := sequenceKey(req.Context())
+
+	best, bestScore := trackNotFound, 0.0
+	for idx := range cassette.Tracks {
+		track := &cassette.Tracks[idx]
+		if track.replayed || track.Request.Fingerprint != "" ||
+			(key != "" && track.SequenceKey != key) || track.Variant != variant(req.Context()) {
+			continue
+		}
+
+		filteredTrackHeader, filteredTrackBody := pcbr.RequestFilterFunc(track.Request.Header, track.Request.Body)
+		trackURL := pcbr.scoredURL(track.Request.URL, ignoreHost)
+
+		var score, total float64
+		add := func(weight, similarity float64) {
+			score += weight * similarity
+			total += weight
+		}
+
+		add(weights.Method, boolSimilarity(track.Request.Method == req.Method))
+		if trackURL == nil || reqURL == nil {
+			same := boolSimilarity(trackURL == reqURL)
+			add(weights.Host, same)
+			add(weights.Path, same)
+			add(weights.Query, same)
+		} else {
+			add(weights.Host, boolSimilarity(trackURL.Scheme == reqURL.Scheme && trackURL.Host == reqURL.Host))
+			add(weights.Path, pathSimilarity(trackURL.Path, reqURL.Path))
+			add(weights.Query, valuesSimilarity(trackURL.Query(), reqURL.Query(), nil))
+		}
+		add(weights.Header, valuesSimilarity(pcbr.normaliseHeader(*filteredTrackHeader), reqHeader, pcbr.ExcludeHeaderFunc))
+		if weights.Body != 0 {
+			trackBody := pcbr.canonicalBody(*filteredReqHeader, *filteredTrackBody)
+			add(weights.Body, boolSimilarity(pcbr.trackBodyResembles(*track, trackBody, reqBody)))
+		}
+
+		if total == 0 {
+			continue
+		}
+		if score /= total; score >= threshold && score > bestScore {
+			best, bestScore = idx, score
+		}
+	}
+
+	return best, bestScore
+}
+
+// scoredURL returns the form of the URL that is scored by bestMatchTrack, which is that compared
+// by urlResembles, or nil.
+func (pcbr *pcb) scoredURL(u *url.URL, ignoreHost bool) *url.URL {
+	if u == nil {
+		return nil
+	}
+
+	scored, err := url.Parse(pcbr.matchedURL(u, ignoreHost))
+	if err != nil {
+		return u
+	}
+
+	return scored
+}
+
+// boolSimilarity returns the similarity of parts that agree or not.
+func boolSimilarity(same bool) float64 {
+	if same {
+		return 1
+	}
+
+	return 0
+}
+
+// pathSimilarity returns the share of the segments of the paths that agree, position by position.
+func pathSimilarity(path1, path2 string) float64 {
+	segments1 := strings.Split(strings.Trim(path1, "/"), "/")
+	segments2 := strings.Split(strings.Trim(path2, "/"), "/")
+
+	n := len(segments1)
+	if len(segments2) > n {
+		n = len(segments2)
+	}
+
+	same := 0
+	for i := 0; i < len(segments1) && i < len(segments2); i++ {
+		if segments1[i] == segments2[i] {
+			same++
+		}
+	}
+
+	return float64(same) / float64(n)
+}
+
+// valuesSimilarity returns the share of the keys of a or b, less the excluded ones, whose values
+// agree. It is 1 when there is no key.
+func valuesSimilarity(a, b map[string][]string, exclude ExcludeHeaderFunc) float64 {
+	keys := map[string]bool{}
+	for _, m := range []map[string][]string{a, b} {
+		for k := range m {
+			if exclude == nil || !exclude(k) {
+				keys[k] = true
+			}
+		}
+	}
+
+	if len(keys) == 0 {
+		return 1
+	}
+
+	same := 0
+	for k := range keys {
+		if strings.Join(a[k], "\n") == strings.Join(b[k], "\n") {
+			same++
+		}
+	}
+
+	return float64(same) / float64(len(keys))
+}
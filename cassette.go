@@ -2,30 +2,55 @@ package govcr
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
+	"net/http/httputil"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
 )
 
-// request is a recorded HTTP request.
-type request struct {
+// Request is a recorded HTTP request.
+type Request struct {
 	Method string
 	URL    *url.URL
 	Header http.Header
 	Body   []byte
+
+	// Fingerprint is the salted hash of the request that is stored in place of the other fields
+	// when VCRConfig.MatchFingerprintOnly is enabled.
+	Fingerprint string `json:",omitempty"`
+
+	// BodyHash is the hex encoded SHA-256 digest of the (filtered) Body.
+	// It is set in place of Body when VCRConfig.HashRequestBodies is enabled.
+	BodyHash string `json:",omitempty"`
+
+	// Host is the host the request was sent to (i.e. the Host header), which may differ from the
+	// host of the URL. It is empty on tracks recorded by older versions of govcr.
+	Host string `json:",omitempty"`
+
+	// Raw is the request line and the headers as written on the wire by Go's transport.
+	// It is only recorded with VCRConfig.RecordRawRequest and does not take part in matching.
+	Raw string `json:",omitempty"`
 }
 
-// response is a recorded HTTP response.
-type response struct {
+// Response is a recorded HTTP response.
+type Response struct {
 	Status     string
 	StatusCode int
 	Proto      string
@@ -38,20 +63,60 @@ type response struct {
 	TransferEncoding []string
 	Trailer          http.Header
 	TLS              *tls.ConnectionState
+
+	// BodySkipped indicates that the Body was deliberately not recorded.
+	// See VCRConfig.SkipBodyContentTypes.
+	BodySkipped bool `json:",omitempty"`
+}
+
+// httpRequest re-creates an HTTP request from the recorded request.
+func (r *Request) httpRequest() *http.Request {
+	req := &http.Request{
+		Method: r.Method,
+		URL:    r.URL,
+		Header: r.Header,
+		Body:   toReadCloser(r.Body),
+	}
+
+	req.Host = r.Host
+	if req.Host == "" && req.URL != nil {
+		req.Host = req.URL.Host
+	}
+
+	return req
 }
 
-// track is a recording (HTTP request + response) in a cassette.
-type track struct {
-	Request  request
-	Response response
+// Track is a recording (HTTP request + response) in a cassette.
+type Track struct {
+	Request  Request
+	Response Response
 	ErrType  string
 	ErrMsg   string
 
+	// RemoteAddr is the address of the server the live request was sent to.
+	// It is informational only and does not take part in matching.
+	RemoteAddr string `json:",omitempty"`
+
+	// SequenceKey is the key supplied with WithSequenceKey when the track was recorded.
+	SequenceKey string `json:",omitempty"`
+
+	// Variant is the variant supplied with WithVariant when the track was recorded.
+	Variant string `json:",omitempty"`
+
+	// Timings are the durations of the phases of the live request, i.e. to compare performance
+	// against a recorded baseline. They are informational only and nil on tracks recorded by older
+	// versions of govcr.
+	Timings *Timings `json:",omitempty"`
+
 	// replayed indicates whether the track has already been processed in the cassette playback.
 	replayed bool
+
+	// sequence orders the track on a cassette stored with VCRConfig.OneFilePerTrack.
+	// It is zero until the track is saved.
+	sequence int
 }
 
-func (t *track) response(req *http.Request) *http.Response {
+func (t *Track) response(req *http.Request) *http.Response {
 	var (
 		err  error
 		resp = &http.Response{}
@@ -88,6 +153,10 @@ func (t *track) response(req *http.Request) *http.Response {
 	tls := t.Response.TLS
 
 	resp.Status = t.Response.Status
+	if resp.Status == "" && t.Response.StatusCode != 0 {
+		// the tracks of hand written or legacy cassettes may only hold the status code
+		resp.Status = fmt.Sprintf("%d %s", t.Response.StatusCode, http.StatusText(t.Response.StatusCode))
+	}
 	resp.StatusCode = t.Response.StatusCode
 	resp.Proto = t.Response.Proto
 	resp.ProtoMajor = t.Response.ProtoMajor
@@ -107,51 +176,89 @@ func (t *track) response(req *http.Request) *http.Response {
 	return resp
 }
 
-// newTrack creates a new track from an HTTP request and response.
-func newTrack(req *http.Request, resp *http.Response, reqErr error) (*track, error) {
-	var (
-		k7Request  request
-		k7Response response
-	)
+// HTTPResponse re-creates the recorded HTTP response, i.e. to feed it to a parser outside of
+// the VCR's client. Each call returns an independent response with a fresh body.
+// It returns nil when the track holds a transport error rather than a response.
+func (t Track) HTTPResponse() *http.Response {
+	if t.ErrType != "" {
+		return nil
+	}
 
-	// build request object
-	if req != nil {
-		bodyData, err := readRequestBody(req)
-		if err != nil {
-			return nil, err
-		}
+	resp := t.response(t.Request.httpRequest())
+	resp.Header = cloneHeader(resp.Header)
+	resp.Trailer = cloneHeader(resp.Trailer)
 
-		k7Request = request{
-			Method: req.Method,
-			URL:    req.URL,
-			Header: req.Header,
-			Body:   bodyData,
-		}
+	return resp
+}
+
+// newRequest creates a recorded request from an HTTP request.
+// The body of req is restored so that it can be read again.
+func newRequest(req *http.Request) (Request, error) {
+	if req == nil {
+		return Request{}, nil
 	}
 
-	// build response object
-	if resp != nil {
-		bodyData, err := readResponseBody(resp)
-		if err != nil {
-			return nil, err
-		}
+	bodyData, err := readRequestBody(req)
+	if err != nil {
+		return Request{}, err
+	}
 
-		k7Response = response{
-			Status:     resp.Status,
-			StatusCode: resp.StatusCode,
-			Proto:      resp.Proto,
-			ProtoMajor: resp.ProtoMajor,
-			ProtoMinor: resp.ProtoMinor,
-
-			Header:           resp.Header,
-			Body:             bodyData,
-			ContentLength:    resp.ContentLength,
-			TransferEncoding: resp.TransferEncoding,
-			Trailer:          resp.Trailer,
-			TLS:              resp.TLS,
+	return Request{
+		Method: req.Method,
+		URL:    req.URL,
+		Header: req.Header,
+		Body:   bodyData,
+		Host:   requestHost(req),
+	}, nil
+}
+
+// newResponse creates a recorded response from an HTTP response.
+// The body of resp is restored so that it can be read again.
+func newResponse(resp *http.Response) (Response, error) {
+	if resp == nil {
+		return Response{}, nil
+	}
+
+	// the body of a 101 response is the connection of the new protocol (i.e. WebSocket frames),
+	// which is left to the client and not recorded
+	var bodyData []byte
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		var err error
+		if bodyData, err = readResponseBody(resp); err != nil {
+			return Response{}, err
 		}
 	}
 
+	return Response{
+		Status:     resp.Status,
+		StatusCode: resp.StatusCode,
+		Proto:      resp.Proto,
+		ProtoMajor: resp.ProtoMajor,
+		ProtoMinor: resp.ProtoMinor,
+
+		Header:           resp.Header,
+		Body:             bodyData,
+		ContentLength:    resp.ContentLength,
+		TransferEncoding: resp.TransferEncoding,
+		Trailer:          resp.Trailer,
+		TLS:              resp.TLS,
+	}, nil
+}
+
+// newTrack creates a new track from an HTTP request and response.
+func newTrack(req *http.Request, resp *http.Response, reqErr error) (*Track, error) {
+	// build request object
+	k7Request, err := newRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// build response object
+	k7Response, err := newResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+
 	// build track object
 	var reqErrType, reqErrMsg string
 	if reqErr != nil {
@@ -159,7 +266,7 @@ func newTrack(req *http.Request, resp *http.Response, reqErr error) (*track, err
 		reqErrMsg = reqErr.Error()
 	}
 
-	track := &track{
+	track := &Track{
 		Request:  k7Request,
 		Response: k7Response,
 		ErrType:  reqErrType,
@@ -181,18 +288,156 @@ type Stats struct {
 	// TracksPlayed is the number of tracks played back straight from the cassette.
 	// I.e. tracks that were already present on the cassette and were played back.
 	TracksPlayed int
+
+	// TrackCount is the total number of tracks currently held on the cassette.
+	TrackCount int
+
+	// CassetteBytes is the size in bytes of the cassette file, as last loaded from or saved to disk.
+	CassetteBytes int64
+
+	// TracksBestMatched is the number of tracks played back by VCRConfig.BestMatch, i.e. without an
+	// exact match. Those are counted in TracksPlayed too.
+	TracksBestMatched int
+
+	// LowestBestMatchScore is the lowest score of the tracks played back by VCRConfig.BestMatch,
+	// i.e. the loosest of the matches. The score of each track is logged.
+	LowestBestMatchScore float64
 }
 
-// cassette contains a set of tracks.
-type cassette struct {
+// Cassette contains a set of tracks.
+type Cassette struct {
 	Name, Path string
-	Tracks     []track
+	Tracks     []Track
+
+	// Filters are declarative transformations stored in the cassette file and applied to the
+	// responses it plays back. They are edited by hand and preserved when the cassette is saved.
+	Filters *CassetteFilters `json:",omitempty"`
+
+	// RecordedAt is the time at which the clock of the VCR was first read for the cassette, as per
+	// VCRConfig.Now. It is the frozen time of VCRControlPanel.Now on replay.
+	// It is nil on cassettes recorded by older versions of govcr.
+	RecordedAt *time.Time `json:",omitempty"`
 
 	// stats is unexported since it doesn't need serialising
 	stats Stats
+
+	// size is the size of the cassette file on disk.
+	size int64
+
+	// tempDir is the directory where the cassette file is written before being moved in place.
+	tempDir string
+
+	// lastReplayed is the number of the last track played back, plus one, by sequence key.
+	lastReplayed map[string]int
+
+	// key is the key the cassette is stored under, or empty to use Name.
+	// See VCRConfig.KeyFunc.
+	key string
+
+	// transport is the transport of the VCR that the cassette is loaded in, if any.
+	transport *vcrTransport
+
+	// format is the format of the cassette file. See VCRConfig.Format.
+	format CassetteFormat
+
+	// fileLock and fileLockTimeout are VCRConfig.FileLock and VCRConfig.FileLockTimeout.
+	fileLock        bool
+	fileLockTimeout time.Duration
+
+	// fileLocked indicates whether the lock of the cassette file is already held by the caller of
+	// save.
+	fileLocked bool
+
+	// savedTracks is the number of tracks of the cassette file when it was last read or written.
+	savedTracks int
+
+	// unsavedTracks is the number of tracks added to the cassette since it was last saved.
+	unsavedTracks int
+}
+
+// Find returns the first track of the cassette that matches the request, whether it has been
+// played back or not. This permits asserting on the recorded response that serves a request
+// without executing it.
+// The matching is that of the VCR that the cassette is loaded in (see VCRControlPanel.Cassette),
+// with the same filters and normalisation as playback. Cassettes loaded with LoadCassetteFrom
+// use the default matching.
+func (k7 *Cassette) Find(req Request) (*Track, bool) {
+	pcbr := defaultPCB()
+	if k7.transport != nil {
+		k7.transport.mu.Lock()
+		defer k7.transport.mu.Unlock()
+
+		pcbr = k7.transport.PCB
+	}
+
+	for idx := range k7.Tracks {
+		if pcbr.trackMatches(k7, idx, req.httpRequest(), false) {
+			track := k7.Tracks[idx]
+			return &track, true
+		}
+	}
+
+	return nil, false
 }
 
-func (k7 *cassette) replayResponse(trackNumber int, req *http.Request) *http.Response {
+// filename returns the name of the file of the cassette.
+func (k7 *Cassette) filename() string {
+	key := k7.key
+	if key == "" {
+		key = k7.Name
+	}
+
+	return cassetteFilename(key, k7.Path, k7.format)
+}
+
+// CassetteFilters are declarative transformations applied to the responses played back from a
+// cassette, before VCRConfig.ResponseFilterFunc. They make a cassette self-describing:
+//
+//	"Filters": {
+//	    "SetResponseHeaders": {"Date": "Mon, 02 Jan 2006 15:04:05 GMT"},
+//	    "DeleteResponseHeaders": ["Set-Cookie"]
+//	}
+type CassetteFilters struct {
+	// SetResponseHeaders sets the value of the response headers, replacing any recorded value.
+	SetResponseHeaders map[string]string `json:",omitempty"`
+
+	// DeleteResponseHeaders deletes the response headers.
+	DeleteResponseHeaders []string `json:",omitempty"`
+}
+
+// apply applies the filters to the header of a replayed response.
+func (f *CassetteFilters) apply(header http.Header) http.Header {
+	if f == nil {
+		return header
+	}
+
+	header = cloneHeader(header)
+	if header == nil {
+		header = http.Header{}
+	}
+
+	for _, k := range f.DeleteResponseHeaders {
+		deleteHeader(header, k)
+	}
+
+	for k, v := range f.SetResponseHeaders {
+		deleteHeader(header, k)
+		header[k] = []string{v}
+	}
+
+	return header
+}
+
+// deleteHeader deletes a header regardless of the case of its key.
+func deleteHeader(header http.Header, key string) {
+	for k := range header {
+		if strings.EqualFold(k, key) {
+			delete(header, k)
+		}
+	}
+}
+
+func (k7 *Cassette) replayResponse(trackNumber int, req *http.Request) *http.Response {
 	if trackNumber == trackNotFound || trackNumber >= len(k7.Tracks) {
 		return nil
 	}
@@ -204,52 +449,166 @@ func (k7 *cassette) replayResponse(trackNumber int, req *http.Request) *http.Res
 	return track.response(req)
 }
 
-// saveCassette writes a cassette to file.
-func (k7 *cassette) save() error {
-	// marshal
-	data, err := json.Marshal(k7)
+// marshal encodes the cassette in the format of cassette files.
+func (k7 *Cassette) marshal() ([]byte, error) {
+	return marshalIndent(k7)
+}
+
+// marshalIndent encodes v in indented JSON, as found in cassette files.
+func marshalIndent(v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// transform properties known to fail on Unmarshal
 	data, err = transformInterfacesInJSON(data)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// beautify JSON (now that the JSON text has been transformed)
 	var iData bytes.Buffer
 
 	if err := json.Indent(&iData, data, "", "  "); err != nil {
+		return nil, err
+	}
+
+	return iData.Bytes(), nil
+}
+
+// saveCassette writes a cassette to file.
+func (k7 *Cassette) save() error {
+	if t := k7.transport; t != nil && k7 == t.Cassette && k7.RecordedAt == nil && atomic.LoadInt32(&t.nowRead) == 1 {
+		now := t.now
+		k7.RecordedAt = &now
+	}
+
+	if k7.fileLock {
+		if !k7.fileLocked {
+			unlock, err := lockFile(k7.lockFilename(), k7.fileLockTimeout)
+			if err != nil {
+				return err
+			}
+			defer unlock()
+		}
+
+		if err := k7.mergeTracksOnDisk(); err != nil {
+			return err
+		}
+	}
+
+	if k7.format == formatTrackFiles {
+		if err := k7.saveTrackFiles(); err != nil {
+			return err
+		}
+		k7.savedTracks, k7.unsavedTracks = len(k7.Tracks), 0
+		return nil
+	}
+
+	data, err := k7.encode(k7.format)
+	if err != nil {
 		return err
 	}
 
 	// write cassette to file
-	filename := cassetteNameToFilename(k7.Name, k7.Path)
+	filename := k7.filename()
 	path := filepath.Dir(filename)
 	if err := os.MkdirAll(path, 0750); err != nil {
 		return err
 	}
 
-	err = ioutil.WriteFile(filename, iData.Bytes(), 0640)
-	return err
+	if err := writeFileAtomic(filename, data, k7.tempDir); err != nil {
+		return err
+	}
+
+	k7.size = int64(len(data))
+	k7.savedTracks, k7.unsavedTracks = len(k7.Tracks), 0
+
+	return nil
+}
+
+// writeFileAtomic writes data to a temporary file in tempDir and then renames it to filename, so
+// that filename never holds partially written data. tempDir defaults to the directory of filename,
+// which guarantees that the rename does not cross filesystems. filename is left untouched when the
+// rename fails (i.e. tempDir is on another filesystem).
+func writeFileAtomic(filename string, data []byte, tempDir string) error {
+	if tempDir == "" {
+		tempDir = filepath.Dir(filename)
+	}
+
+	tmp, err := ioutil.TempFile(tempDir, filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := os.Chmod(tmp.Name(), 0640); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filename)
+}
+
+// WriteTo writes the cassette to w in the same format as cassette files.
+// It implements io.WriterTo.
+func (k7 *Cassette) WriteTo(w io.Writer) (int64, error) {
+	data, err := k7.marshal()
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := w.Write(data)
+	return int64(n), err
 }
 
 // addTrack adds a track to a cassette.
-func (k7 *cassette) addTrack(track *track) {
+func (k7 *Cassette) addTrack(track *Track) {
 	k7.Tracks = append(k7.Tracks, *track)
+	k7.unsavedTracks++
+}
+
+// compact removes the tracks that have not been replayed and returns the number of tracks removed.
+func (k7 *Cassette) compact() int {
+	tracks := k7.Tracks[:0]
+	for _, t := range k7.Tracks {
+		if t.replayed {
+			tracks = append(tracks, t)
+		}
+	}
+
+	removed := len(k7.Tracks) - len(tracks)
+	k7.Tracks = tracks
+
+	// recorded tracks are always marked as replayed: only loaded tracks have been removed
+	k7.stats.TracksLoaded -= removed
+
+	return removed
 }
 
 // Stats returns the cassette's Stats.
-func (k7 *cassette) Stats() Stats {
-	k7.stats.TracksRecorded = k7.numberOfTracks() - k7.stats.TracksLoaded
+func (k7 *Cassette) Stats() Stats {
+	k7.stats.TracksRecorded = k7.tracksRecorded()
 	k7.stats.TracksPlayed = k7.tracksPlayed() - k7.stats.TracksRecorded
+	k7.stats.TrackCount = k7.numberOfTracks()
+	k7.stats.CassetteBytes = k7.size
 
 	return k7.stats
 }
 
-func (k7 *cassette) tracksPlayed() int {
+func (k7 *Cassette) tracksRecorded() int {
+	return k7.numberOfTracks() - k7.stats.TracksLoaded
+}
+
+func (k7 *Cassette) tracksPlayed() int {
 	replayed := 0
 
 	for _, t := range k7.Tracks {
@@ -261,21 +620,229 @@ func (k7 *cassette) tracksPlayed() int {
 	return replayed
 }
 
-func (k7 *cassette) numberOfTracks() int {
+func (k7 *Cassette) numberOfTracks() int {
 	return len(k7.Tracks)
 }
 
-// DeleteCassette removes the cassette file from disk.
+// DeleteCassette removes the cassette file from disk, in any of the formats.
 func DeleteCassette(cassetteName, cassettePath string) error {
-	filename := cassetteNameToFilename(cassetteName, cassettePath)
+	for _, format := range []CassetteFormat{FormatJSON, FormatGob} {
+		// the file not existing is not an error since we wanted it gone!
+		err := os.Remove(cassetteFilename(cassetteName, cassettePath, format))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
 
-	err := os.Remove(filename)
-	if os.IsNotExist(err) {
-		// the file does not exist so this is not an error since we wanted it gone!
-		err = nil
+	// only the track files are removed from the directory of a cassette stored with
+	// VCRConfig.OneFilePerTrack, in case it is shared with other files
+	return removeTrackFiles(cassetteFilename(cassetteName, cassettePath, formatTrackFiles), nil)
+}
+
+// ForEachCassette calls fn with each cassette under cassettePath whose name matches the glob
+// pattern (see path.Match), i.e. "api/*". Cassettes are looked for in all of the storage formats
+// (FormatJSON, FormatGob and VCRConfig.OneFilePerTrack) and are loaded before fn is called.
+// Iteration stops at the first error returned by fn, or at the first cassette that cannot be loaded.
+func ForEachCassette(pattern, cassettePath string, fn func(*Cassette) error) error {
+	files, err := globCassettes(pattern, cassettePath)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		k7, err := loadCassette(file.name, cassettePath, true, file.format)
+		if err != nil {
+			return err
+		}
+		if k7.Name == "" {
+			k7.Name = file.name
+		}
+
+		if err := fn(k7); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DeleteCassettes removes the cassettes under cassettePath whose name matches the glob pattern
+// (see path.Match), i.e. "api/*", in any of the storage formats. Unlike ForEachCassette, the
+// cassettes are not loaded, so that corrupt cassettes are removed too.
+func DeleteCassettes(pattern, cassettePath string) error {
+	files, err := globCassettes(pattern, cassettePath)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if err := DeleteCassette(file.name, cassettePath); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CassetteInfo describes a cassette file. See ListCassettes.
+type CassetteInfo struct {
+	// Name is the name of the cassette, as supplied to NewVCR.
+	Name string
+
+	// Path is the absolute path of the cassette file, or of its directory with
+	// VCRConfig.OneFilePerTrack.
+	Path string
+
+	// Size is the size in bytes of the cassette file, or of its track files with
+	// VCRConfig.OneFilePerTrack.
+	Size int64
+
+	// TrackCount is the number of tracks on the cassette.
+	TrackCount int
+}
+
+// ListCassettes returns information about the cassettes found under cassettePath, including
+// those in sub-directories, sorted by name. Cassettes are looked for in all of the storage formats.
+// The tracks of FormatJSON cassettes are counted without being fully decoded.
+func ListCassettes(cassettePath string) ([]CassetteInfo, error) {
+	files, err := findCassettes(cassettePath)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]CassetteInfo, 0, len(files))
+	for _, file := range files {
+		info := CassetteInfo{Name: file.name, Path: file.filename}
+		if file.format == FormatJSON {
+			info.Size, info.TrackCount, err = countJSONTracks(file.filename)
+		} else {
+			var k7 *Cassette
+			k7, err = readCassetteFile(file.filename)
+			if k7 != nil {
+				info.Size, info.TrackCount = k7.size, len(k7.Tracks)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file.filename, err)
+		}
+
+		infos = append(infos, info)
+	}
+
+	return infos, nil
+}
+
+// countJSONTracks returns the size of a FormatJSON cassette file and its number of tracks.
+func countJSONTracks(filename string) (int64, int, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var k7 struct {
+		Tracks []json.RawMessage
+	}
+	if err := json.Unmarshal(data, &k7); err != nil {
+		return 0, 0, err
+	}
+
+	return int64(len(data)), len(k7.Tracks), nil
+}
+
+// cassetteFile is a cassette found on disk by findCassettes.
+type cassetteFile struct {
+	name     string
+	filename string
+	format   CassetteFormat
+}
+
+// findCassettes returns the cassettes under cassettePath, including those in sub-directories, in
+// all of the storage formats, sorted by name. A cassette stored in both FormatJSON and FormatGob
+// is returned once per format.
+func findCassettes(cassettePath string) ([]cassetteFile, error) {
+	if cassettePath == "" {
+		cassettePath = defaultCassettePath
+	}
+
+	root, err := filepath.Abs(cassettePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []cassetteFile
+	err = filepath.Walk(root, func(filename string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if filename == root && os.IsNotExist(err) {
+				// no cassette has been recorded yet
+				return nil
+			}
+			return err
+		}
+
+		file := cassetteFile{filename: filename}
+		switch {
+		case fi.IsDir():
+			if filename == root {
+				return nil
+			}
+			if _, err := os.Stat(filepath.Join(filename, trackFilesHeader)); err != nil {
+				return nil
+			}
+			file.format = formatTrackFiles
+		case strings.HasSuffix(filename, gobExtension):
+			file.format = FormatGob
+		case strings.HasSuffix(filename, cassetteExtension):
+			file.format = FormatJSON
+		default:
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, strings.TrimSuffix(filename, file.format.extension()))
+		if err != nil {
+			return err
+		}
+		file.name = filepath.ToSlash(rel)
+		files = append(files, file)
+
+		if fi.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(files, func(i, j int) bool { return files[i].name < files[j].name })
+
+	return files, nil
+}
+
+// globCassettes returns the cassettes under cassettePath whose name matches the glob pattern,
+// once per name. The format of a cassette stored in both FormatJSON and FormatGob is left for
+// loadCassette to report as ErrAmbiguousCassetteFormat.
+func globCassettes(pattern, cassettePath string) ([]cassetteFile, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+
+	files, err := findCassettes(cassettePath)
+	if err != nil {
+		return nil, err
+	}
+
+	matched := make([]cassetteFile, 0, len(files))
+	for _, file := range files {
+		if ok, _ := path.Match(pattern, file.name); !ok {
+			continue
+		}
+		if len(matched) > 0 && matched[len(matched)-1].name == file.name {
+			continue
+		}
+		matched = append(matched, file)
 	}
 
-	return err
+	return matched, nil
 }
 
 // CassetteExistsAndValid verifies a cassette file exists and is seemingly valid.
@@ -284,8 +851,16 @@ func CassetteExistsAndValid(cassetteName, cassettePath string) bool {
 	return err == nil
 }
 
+// cassetteExtension is the extension of cassette files.
+const cassetteExtension = ".cassette"
+
 // cassetteNameToFilename returns the filename associated to the cassette.
 func cassetteNameToFilename(cassetteName, cassettePath string) string {
+	return cassetteFilename(cassetteName, cassettePath, FormatJSON)
+}
+
+// cassetteFilename returns the filename associated to the cassette in the format.
+func cassetteFilename(cassetteName, cassettePath string, format CassetteFormat) string {
 	if cassetteName == "" {
 		return ""
 	}
@@ -294,7 +869,7 @@ func cassetteNameToFilename(cassetteName, cassettePath string) string {
 		cassettePath = defaultCassettePath
 	}
 
-	fpath, err := filepath.Abs(filepath.Join(cassettePath, cassetteName+".cassette"))
+	fpath, err := filepath.Abs(filepath.Join(cassettePath, cassetteName+format.extension()))
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -321,26 +896,48 @@ func transformInterfacesInJSON(jsonString []byte) ([]byte, error) {
 	return []byte(regex.ReplaceAllString(string(jsonString), `$1"$2",`)), nil
 }
 
-func loadCassette(cassetteName, cassettePath string) (*cassette, error) {
-	k7, err := readCassetteFromFile(cassetteName, cassettePath)
+// ErrCassetteNotFound is the error reported when the cassette file does not exist
+// and VCRConfig.RequireCassetteExists is enabled.
+var ErrCassetteNotFound = errors.New("govcr: cassette not found")
+
+func loadCassette(cassetteName, cassettePath string, requireExists bool, format CassetteFormat) (*Cassette, error) {
+	format, err := probeCassetteFormat(cassetteName, cassettePath, format)
+	if err != nil {
+		return nil, err
+	}
+
+	k7, err := readCassetteFile(cassetteFilename(cassetteName, cassettePath, format))
 	if err != nil && !os.IsNotExist(err) {
 		return nil, err
 	}
 
+	if k7 == nil && requireExists {
+		return nil, fmt.Errorf("%w: %s", ErrCassetteNotFound, cassetteFilename(cassetteName, cassettePath, format))
+	}
+
 	// provide an empty cassette as a minimum
 	if k7 == nil {
-		k7 = &cassette{Name: cassetteName, Path: cassettePath}
+		k7 = &Cassette{Name: cassetteName, Path: cassettePath}
 	}
+	k7.format = format
 
 	// initial stats
 	k7.stats.TracksLoaded = len(k7.Tracks)
+	k7.savedTracks = len(k7.Tracks)
 
 	return k7, nil
 }
 
 // readCassetteFromFile reads the cassette file, if present.
-func readCassetteFromFile(cassetteName, cassettePath string) (*cassette, error) {
-	filename := cassetteNameToFilename(cassetteName, cassettePath)
+func readCassetteFromFile(cassetteName, cassettePath string) (*Cassette, error) {
+	return readCassetteFile(cassetteNameToFilename(cassetteName, cassettePath))
+}
+
+// readCassetteFile reads a cassette file in the format of its extension.
+func readCassetteFile(filename string) (*Cassette, error) {
+	if fi, err := os.Stat(filename); err == nil && fi.IsDir() {
+		return readTrackFiles(filename)
+	}
 
 	// retrieve cassette from file
 	data, err := ioutil.ReadFile(filename)
@@ -348,24 +945,52 @@ func readCassetteFromFile(cassetteName, cassettePath string) (*cassette, error)
 		return nil, err
 	}
 
-	// unmarshal
-	cassette := &cassette{}
+	return decodeCassette(data, formatOf(filename))
+}
+
+// unmarshalCassette decodes a cassette from data in the format of cassette files.
+func unmarshalCassette(data []byte) (*Cassette, error) {
+	cassette := &Cassette{}
 	// NOTE: Properties which are of type 'interface{}' are not handled very well
 	if err := json.Unmarshal(data, cassette); err != nil {
 		return nil, err
 	}
 
+	cassette.size = int64(len(data))
+
 	return cassette, nil
 }
 
+// LoadCassetteFrom reads a cassette from r, in the same format as cassette files.
+// This permits storing cassettes elsewhere than on the filesystem (database, HTTP endpoint, etc).
+func LoadCassetteFrom(r io.Reader) (*Cassette, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	k7, err := unmarshalCassette(data)
+	if err != nil {
+		return nil, err
+	}
+
+	k7.stats.TracksLoaded = len(k7.Tracks)
+
+	return k7, nil
+}
+
 // recordNewTrackToCassette saves a new track to a cassette.
-func recordNewTrackToCassette(cassette *cassette, req *http.Request, resp *http.Response, httpErr error) error {
-	// create track
-	track, err := newTrack(req, resp, httpErr)
+func (pcbr *pcb) recordNewTrackToCassette(cassette *Cassette, req *http.Request, resp *http.Response, httpErr error, trace *liveTrace) error {
+	track, err := pcbr.newRecordedTrack(req, resp, httpErr, trace)
 	if err != nil {
 		return err
 	}
 
+	if pcbr.RecordIf != nil && !pcbr.RecordIf(track.Response) {
+		pcbr.Logger.Printf("INFO - Cassette '%s' - RecordIf declined to record the track for %s %s\n", cassette.Name, req.Method, req.URL.String())
+		return nil
+	}
+
 	// mark track as replayed since it's coming from a live request!
 	track.replayed = true
 
@@ -375,3 +1000,95 @@ func recordNewTrackToCassette(cassette *cassette, req *http.Request, resp *http.
 	// save cassette
 	return cassette.save()
 }
+
+// newRecordedTrack creates the track, as saved on the cassette, of the live HTTP traffic.
+func (pcbr *pcb) newRecordedTrack(req *http.Request, resp *http.Response, httpErr error, trace *liveTrace) (*Track, error) {
+	// create track
+	track, err := newTrack(req, resp, httpErr)
+	if err != nil {
+		return nil, err
+	}
+
+	if trace != nil {
+		trace.applyTo(track)
+	}
+
+	if pcbr.RecordRawRequest {
+		rawReq := req
+		if pcbr.RedactHeaderValueRegex != nil {
+			rawReq = req.Clone(req.Context())
+			rawReq.Header = pcbr.redactHeaderValues(req.Header)
+		}
+		raw, err := httputil.DumpRequestOut(rawReq, false)
+		if err != nil {
+			return nil, err
+		}
+		track.Request.Raw = string(raw)
+	}
+
+	track.Request.Header = pcbr.recordedRequestHeader(track.Request.Header)
+	track.SequenceKey = sequenceKey(req.Context())
+	track.Variant = variant(req.Context())
+
+	if pcbr.HashRequestBodies {
+		filteredHeader, filteredBody := pcbr.RequestFilterFunc(track.Request.Header, track.Request.Body)
+		track.Request.BodyHash = hashBody(pcbr.canonicalBody(*filteredHeader, *filteredBody))
+		track.Request.Body = nil
+	}
+
+	track.Response.Header = pcbr.recordedResponseHeader(track.Response.Header)
+
+	if !pcbr.FrozenTime.IsZero() {
+		track.Response.Header = pcbr.freezeTimeHeaders(track.Response.Header)
+	}
+
+	if pcbr.RecordResponseFilterFunc != nil {
+		newHeader, newBody := pcbr.RecordResponseFilterFunc(cloneHeader(track.Response.Header), track.Response.Body, track.Request.Header)
+		track.Response.Header = *newHeader
+		track.Response.Body = *newBody
+	}
+
+	if pcbr.skipBody(track.Response.Header) {
+		track.Response.Body = nil
+		track.Response.BodySkipped = true
+
+		// the length is that of the (empty) body played back
+		track.Response.ContentLength = 0
+		track.Response.Header = cloneHeader(track.Response.Header)
+		track.Response.Header.Del("Content-Length")
+	}
+
+	if pcbr.MatchFingerprintOnly {
+		fingerprint, err := pcbr.fingerprint(track.Request.httpRequest())
+		if err != nil {
+			return nil, err
+		}
+		track.Request = Request{Fingerprint: fingerprint}
+	}
+
+	return track, nil
+}
+
+// freezeTimeHeaders returns a copy of the header with the value of the FreezeTimeHeaders that are
+// present set to FrozenTime.
+func (pcbr *pcb) freezeTimeHeaders(header http.Header) http.Header {
+	header = cloneHeader(header)
+	frozen := pcbr.FrozenTime.UTC().Format(http.TimeFormat)
+
+	for k := range header {
+		for _, name := range pcbr.FreezeTimeHeaders {
+			if strings.EqualFold(k, name) {
+				header[k] = []string{frozen}
+				break
+			}
+		}
+	}
+
+	return header
+}
+
+// hashBody returns the hex encoded SHA-256 digest of a body.
+func hashBody(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,40 @@
+package govcr
+
+import "context"
+
+// sequenceKeyContextKey is the context key of the sequence key. See WithSequenceKey.
+type sequenceKeyContextKey struct{}
+
+// WithSequenceKey returns a copy of ctx that carries a sequence key, i.e. the identifier of a
+// worker goroutine. Requests made with this context are recorded on tracks tagged with the key
+// and are only replayed from tracks with the same key.
+//
+// Identical requests are replayed in the order they were recorded. The sequence key partitions
+// this order: each key has its own sequence, which keeps the replay of concurrent workers
+// deterministic. Requests without a key share the sequence of all tracks.
+func WithSequenceKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, sequenceKeyContextKey{}, key)
+}
+
+// sequenceKey returns the sequence key carried by ctx, if any.
+func sequenceKey(ctx context.Context) string {
+	key, _ := ctx.Value(sequenceKeyContextKey{}).(string)
+	return key
+}
+
+// variantContextKey is the context key of the variant. See WithVariant.
+type variantContextKey struct{}
+
+// WithVariant returns a copy of ctx that carries a variant, i.e. the state of a feature flag.
+// Requests made with this context are recorded on tracks tagged with the variant and only match
+// tracks with the same variant. Requests without a variant only match tracks without one.
+// This keeps alternative sets of fixtures on a single cassette.
+func WithVariant(ctx context.Context, variant string) context.Context {
+	return context.WithValue(ctx, variantContextKey{}, variant)
+}
+
+// variant returns the variant carried by ctx, if any.
+func variant(ctx context.Context) string {
+	v, _ := ctx.Value(variantContextKey{}).(string)
+	return v
+}
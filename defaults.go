@@ -0,0 +1,95 @@
+package govcr
+
+import (
+	"net/http"
+	"reflect"
+	"sync"
+)
+
+var (
+	// defaultConfigMu guards defaultConfig.
+	defaultConfigMu sync.Mutex
+
+	// defaultConfig is the configuration set by SetDefaultConfig, if any.
+	defaultConfig *VCRConfig
+)
+
+// SetDefaultConfig sets the configuration that is merged into the configuration of each VCR
+// subsequently created by NewVCR, i.e. from TestMain to apply the same redactions and
+// normalisations to all of the tests of a suite.
+//
+// The configuration of a VCR is merged with the default configuration field by field:
+//  - a field that is set (non-zero) in the configuration of the VCR overrides the default, which
+//    is used otherwise. Note that boolean fields can thus be enabled but not disabled per VCR.
+//  - the filters are appended: RequestFilterFunc, ResponseFilterFunc and
+//    RecordResponseFilterFunc run the default filter, then the filter of the VCR.
+//  - ExcludeHeaderFunc excludes the headers excluded by either function.
+//
+// The configuration of the VCR is left as is, but for VCRConfig.Rand which is set as documented.
+//
+// SetDefaultConfig(VCRConfig{}) removes the default configuration.
+func SetDefaultConfig(cfg VCRConfig) {
+	defaultConfigMu.Lock()
+	defer defaultConfigMu.Unlock()
+
+	if reflect.ValueOf(cfg).IsZero() {
+		defaultConfig = nil
+		return
+	}
+
+	defaultConfig = &cfg
+}
+
+// withDefaultConfig returns the merge of vcrConfig into the configuration set by
+// SetDefaultConfig. vcrConfig is returned as is when there is no default configuration.
+func withDefaultConfig(vcrConfig *VCRConfig) *VCRConfig {
+	defaultConfigMu.Lock()
+	defaults := defaultConfig
+	defaultConfigMu.Unlock()
+
+	if defaults == nil {
+		return vcrConfig
+	}
+
+	merged := *vcrConfig
+	mv, dv := reflect.ValueOf(&merged).Elem(), reflect.ValueOf(defaults).Elem()
+	for i := 0; i < mv.NumField(); i++ {
+		if f := mv.Field(i); f.IsZero() {
+			f.Set(dv.Field(i))
+		}
+	}
+
+	if defaults.RequestFilterFunc != nil && vcrConfig.RequestFilterFunc != nil {
+		merged.RequestFilterFunc = chainRequestFilters(defaults.RequestFilterFunc, vcrConfig.RequestFilterFunc)
+	}
+	if defaults.ResponseFilterFunc != nil && vcrConfig.ResponseFilterFunc != nil {
+		merged.ResponseFilterFunc = chainResponseFilters(defaults.ResponseFilterFunc, vcrConfig.ResponseFilterFunc)
+	}
+	if defaults.RecordResponseFilterFunc != nil && vcrConfig.RecordResponseFilterFunc != nil {
+		merged.RecordResponseFilterFunc = chainResponseFilters(defaults.RecordResponseFilterFunc, vcrConfig.RecordResponseFilterFunc)
+	}
+	if defaults.ExcludeHeaderFunc != nil && vcrConfig.ExcludeHeaderFunc != nil {
+		first, second := defaults.ExcludeHeaderFunc, vcrConfig.ExcludeHeaderFunc
+		merged.ExcludeHeaderFunc = func(key string) bool {
+			return first(key) || second(key)
+		}
+	}
+
+	return &merged
+}
+
+// chainRequestFilters returns a RequestFilterFunc that runs first, then second.
+func chainRequestFilters(first, second RequestFilterFunc) RequestFilterFunc {
+	return func(header http.Header, body []byte) (*http.Header, *[]byte) {
+		newHeader, newBody := first(header, body)
+		return second(*newHeader, *newBody)
+	}
+}
+
+// chainResponseFilters returns a ResponseFilterFunc that runs first, then second.
+func chainResponseFilters(first, second ResponseFilterFunc) ResponseFilterFunc {
+	return func(respHdr http.Header, body []byte, reqHdr http.Header) (*http.Header, *[]byte) {
+		newHeader, newBody := first(respHdr, body, reqHdr)
+		return second(*newHeader, *newBody, reqHdr)
+	}
+}
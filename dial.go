@@ -0,0 +1,59 @@
+package govcr
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+)
+
+// withDialRewrites returns a copy of the transport that connects to the addresses of rewrites
+// in place of the hosts they are keyed by. See VCRConfig.DialRewrites.
+// Only *http.Transport can be rewritten: other transports are returned as is.
+func withDialRewrites(rt http.RoundTripper, rewrites map[string]string, logger *log.Logger) http.RoundTripper {
+	if len(rewrites) == 0 {
+		return rt
+	}
+
+	transport, ok := rt.(*http.Transport)
+	if !ok {
+		logger.Printf("WARNING - DialRewrites is ignored since the transport is a %T rather than an *http.Transport\n", rt)
+		return rt
+	}
+
+	transport = transport.Clone()
+	dial := transport.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return dial(ctx, network, rewriteAddr(addr, rewrites))
+	}
+
+	return transport
+}
+
+// rewriteAddr returns the address that addr ("host:port") is rewritten to, as per rewrites.
+// Rewrites keyed by "host:port" take precedence over those keyed by host. The port of addr is
+// kept when the rewritten address has none.
+func rewriteAddr(addr string, rewrites map[string]string) string {
+	if to, ok := rewrites[addr]; ok {
+		return to
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+
+	to, ok := rewrites[host]
+	if !ok {
+		return addr
+	}
+	if _, _, err := net.SplitHostPort(to); err != nil {
+		return net.JoinHostPort(to, port)
+	}
+
+	return to
+}
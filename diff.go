@@ -0,0 +1,262 @@
+package govcr
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// TrackDiffKind is the kind of a TrackDiff.
+type TrackDiffKind int
+
+const (
+	// TrackAdded is a track of the second cassette that matches no track of the first one.
+	TrackAdded TrackDiffKind = iota + 1
+
+	// TrackRemoved is a track of the first cassette that matches no track of the second one.
+	TrackRemoved
+
+	// TrackChanged is a track of the first cassette whose response differs in the second one.
+	TrackChanged
+)
+
+// String returns the name of the kind, i.e. to render a TrackDiff.
+func (k TrackDiffKind) String() string {
+	switch k {
+	case TrackAdded:
+		return "added"
+	case TrackRemoved:
+		return "removed"
+	case TrackChanged:
+		return "changed"
+	}
+
+	return "unknown"
+}
+
+// TrackDiff is a difference between two cassettes, as reported by DiffCassettes.
+type TrackDiff struct {
+	Kind TrackDiffKind
+
+	// A and B are the numbers of the track in each cassette, -1 when the track is absent.
+	A, B int
+
+	// Method and URL are those of the request of the track.
+	// The URL is empty for the tracks recorded with VCRConfig.MatchFingerprintOnly.
+	Method string
+	URL    string
+
+	// StatusCodes are the response status codes of the track in each cassette.
+	StatusCodes [2]int
+
+	// Errors are the errors ("ErrType: ErrMsg") of the track in each cassette, if any.
+	Errors [2]string
+
+	// Headers lists the names of the response headers that differ, sorted.
+	Headers []string
+
+	// Body is a line by line diff of the response bodies. It is nil when the bodies are the same.
+	Body []LineDiff
+}
+
+// LineDiff is a line of the diff of two bodies.
+type LineDiff struct {
+	// Op is '-' for a line of the first body only, '+' for a line of the second body only and
+	// ' ' for a line of both.
+	Op   byte
+	Line string
+}
+
+// maxBodyDiffCells bounds the size of the table used to diff bodies line by line. Larger bodies
+// are reported as entirely removed and added.
+const maxBodyDiffCells = 1 << 22
+
+// DiffCassettes reports the tracks that were added, removed or changed between the cassette
+// files a and b, i.e. to review what re-recording a cassette changed. The formats of the files
+// are detected from their extensions (see ConvertCassetteFile).
+//
+// The tracks are paired with the matching of playback, with the default VCRConfig: each track of
+// a is paired with the first track of b, not yet paired, whose request it matches. A pair is
+// reported as changed when the response status code, headers, body or error differs.
+// The diffs are returned in the order of the tracks of a, followed by the tracks added to b.
+func DiffCassettes(a, b string) ([]TrackDiff, error) {
+	k7A, err := readCassetteFile(a)
+	if err != nil {
+		return nil, err
+	}
+	k7B, err := readCassetteFile(b)
+	if err != nil {
+		return nil, err
+	}
+
+	pcbr := defaultPCB()
+	paired := make([]bool, len(k7B.Tracks))
+
+	var diffs []TrackDiff
+	for i, trackA := range k7A.Tracks {
+		j := trackNotFound
+		for idx := range k7B.Tracks {
+			if !paired[idx] && pcbr.sameRequest(trackA, k7B, idx) {
+				j = idx
+				break
+			}
+		}
+
+		if j == trackNotFound {
+			diffs = append(diffs, newTrackDiff(TrackRemoved, i, &trackA, -1, nil))
+			continue
+		}
+		paired[j] = true
+
+		if diff := newTrackDiff(TrackChanged, i, &trackA, j, &k7B.Tracks[j]); diff.changed() {
+			diffs = append(diffs, diff)
+		}
+	}
+
+	for j := range k7B.Tracks {
+		if !paired[j] {
+			diffs = append(diffs, newTrackDiff(TrackAdded, -1, nil, j, &k7B.Tracks[j]))
+		}
+	}
+
+	return diffs, nil
+}
+
+// sameRequest indicates whether track a was recorded for the same request as track j of cassette b.
+func (pcbr *pcb) sameRequest(a Track, b *Cassette, j int) bool {
+	trackB := b.Tracks[j]
+	if a.SequenceKey != trackB.SequenceKey || a.Variant != trackB.Variant {
+		return false
+	}
+
+	if a.Request.Fingerprint != "" || trackB.Request.Fingerprint != "" {
+		return a.Request.Fingerprint == trackB.Request.Fingerprint
+	}
+
+	req := a.Request.httpRequest()
+	req = req.WithContext(WithVariant(context.Background(), a.Variant))
+
+	return pcbr.trackMatches(b, j, req, false)
+}
+
+// newTrackDiff returns the diff of the tracks a (number i) and b (number j), either of which may
+// be nil.
+func newTrackDiff(kind TrackDiffKind, i int, a *Track, j int, b *Track) TrackDiff {
+	diff := TrackDiff{Kind: kind, A: i, B: j}
+
+	var respA, respB Response
+	for n, t := range []*Track{a, b} {
+		if t == nil {
+			continue
+		}
+
+		if diff.Method == "" {
+			diff.Method = t.Request.Method
+			if t.Request.URL != nil {
+				diff.URL = t.Request.URL.String()
+			}
+		}
+		diff.StatusCodes[n] = t.Response.StatusCode
+		if t.ErrType != "" || t.ErrMsg != "" {
+			diff.Errors[n] = t.ErrType + ": " + t.ErrMsg
+		}
+
+		if n == 0 {
+			respA = t.Response
+		} else {
+			respB = t.Response
+		}
+	}
+
+	diff.Headers = diffHeaderNames(respA.Header, respB.Header)
+	if !bytes.Equal(respA.Body, respB.Body) {
+		diff.Body = diffLines(string(respA.Body), string(respB.Body))
+	}
+
+	return diff
+}
+
+// changed indicates whether the responses of the diff differ.
+func (d TrackDiff) changed() bool {
+	return d.StatusCodes[0] != d.StatusCodes[1] || d.Errors[0] != d.Errors[1] || len(d.Headers) > 0 || d.Body != nil
+}
+
+// diffHeaderNames returns the sorted names of the headers whose values differ between a and b.
+func diffHeaderNames(a, b http.Header) []string {
+	a, b = canonicalHeader(a), canonicalHeader(b)
+
+	var names []string
+	for k, val := range a {
+		if strings.Join(val, "\n") != strings.Join(b[k], "\n") {
+			names = append(names, k)
+		}
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// diffLines returns the line by line diff of a and b, from their longest common subsequence.
+func diffLines(a, b string) []LineDiff {
+	var linesA, linesB []string
+	if a != "" {
+		linesA = strings.Split(a, "\n")
+	}
+	if b != "" {
+		linesB = strings.Split(b, "\n")
+	}
+
+	var diff []LineDiff
+	if len(linesA)*len(linesB) > maxBodyDiffCells {
+		for _, l := range linesA {
+			diff = append(diff, LineDiff{Op: '-', Line: l})
+		}
+		for _, l := range linesB {
+			diff = append(diff, LineDiff{Op: '+', Line: l})
+		}
+		return diff
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of linesA[i:] and linesB[j:]
+	lcs := make([][]int, len(linesA)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(linesB)+1)
+	}
+	for i := len(linesA) - 1; i >= 0; i-- {
+		for j := len(linesB) - 1; j >= 0; j-- {
+			switch {
+			case linesA[i] == linesB[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(linesA) || j < len(linesB) {
+		switch {
+		case i < len(linesA) && j < len(linesB) && linesA[i] == linesB[j]:
+			diff = append(diff, LineDiff{Op: ' ', Line: linesA[i]})
+			i++
+			j++
+		case j == len(linesB) || (i < len(linesA) && lcs[i+1][j] >= lcs[i][j+1]):
+			diff = append(diff, LineDiff{Op: '-', Line: linesA[i]})
+			i++
+		default:
+			diff = append(diff, LineDiff{Op: '+', Line: linesB[j]})
+			j++
+		}
+	}
+
+	return diff
+}
@@ -0,0 +1,136 @@
+package govcr
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrFileLockTimeout is the error reported when the lock of a cassette file could not be acquired
+// within VCRConfig.FileLockTimeout.
+var ErrFileLockTimeout = errors.New("govcr: timed out waiting for the lock of the cassette file")
+
+// ErrFileLockUnsupported is the error reported when VCRConfig.FileLock is set on a platform that
+// has no advisory file locks.
+var ErrFileLockUnsupported = errors.New("govcr: file locks are not supported on this platform")
+
+// defaultFileLockTimeout is the default of VCRConfig.FileLockTimeout.
+const defaultFileLockTimeout = 30 * time.Second
+
+// fileLockPollInterval is the interval between the attempts to acquire a lock held by another
+// process.
+const fileLockPollInterval = 10 * time.Millisecond
+
+// lockFile acquires an exclusive advisory lock on the lock file of filename (filename + ".lock"),
+// waiting up to timeout for another process to release it. The returned function releases the lock.
+// The lock file is kept: removing it would let two processes lock different files of the same name.
+func lockFile(filename string, timeout time.Duration) (func(), error) {
+	if timeout <= 0 {
+		timeout = defaultFileLockTimeout
+	}
+
+	lockFilename := filename + ".lock"
+	f, err := os.OpenFile(lockFilename, os.O_RDWR|os.O_CREATE, 0640)
+	if os.IsNotExist(err) {
+		// the directory of the cassette does not exist yet
+		if err = os.MkdirAll(filepath.Dir(lockFilename), 0750); err == nil {
+			f, err = os.OpenFile(lockFilename, os.O_RDWR|os.O_CREATE, 0640)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		locked, err := tryLockFile(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if locked {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			f.Close()
+			return nil, fmt.Errorf("%w: %s after %s", ErrFileLockTimeout, lockFilename, timeout)
+		}
+		time.Sleep(fileLockPollInterval)
+	}
+
+	return func() {
+		unlockFile(f)
+		f.Close()
+	}, nil
+}
+
+// lockFilename returns the cassette file that lockFile is called with. The JSON and gob files of a
+// cassette share their lock, since either is loaded whatever VCRConfig.Format is.
+func lockFilename(cassetteName, cassettePath string, format CassetteFormat) string {
+	if format == FormatGob {
+		format = FormatJSON
+	}
+
+	return cassetteFilename(cassetteName, cassettePath, format)
+}
+
+// lockFilename returns the cassette file that lockFile is called with.
+func (k7 *Cassette) lockFilename() string {
+	key := k7.key
+	if key == "" {
+		key = k7.Name
+	}
+
+	return lockFilename(key, k7.Path, k7.format)
+}
+
+// loadLockedCassette loads the cassette as loadCassette does, with the lock of its file held as per
+// VCRConfig.FileLock.
+func loadLockedCassette(cassetteName string, vcrConfig *VCRConfig, format CassetteFormat) (*Cassette, error) {
+	if vcrConfig.FileLock {
+		unlock, err := lockFile(lockFilename(cassetteName, vcrConfig.CassettePath, format), vcrConfig.FileLockTimeout)
+		if err != nil {
+			return nil, err
+		}
+		defer unlock()
+	}
+
+	return loadCassette(cassetteName, vcrConfig.CassettePath, vcrConfig.RequireCassetteExists, format)
+}
+
+// mergeTracksOnDisk adds to the cassette the tracks that other processes appended to its file since
+// the cassette was last read or written by this process, ahead of the tracks added since. The file
+// thus starts with the tracks that each process last read or wrote. It is called with the lock of
+// the cassette file held, before the cassette is saved.
+func (k7 *Cassette) mergeTracksOnDisk() error {
+	onDisk, err := readCassetteFile(k7.filename())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	if len(onDisk.Tracks) <= k7.savedTracks {
+		// no track was appended, the file is overwritten
+		return nil
+	}
+
+	unsaved := k7.unsavedTracks
+	if unsaved > len(k7.Tracks) {
+		unsaved = len(k7.Tracks)
+	}
+	saved := len(k7.Tracks) - unsaved
+
+	added := onDisk.Tracks[k7.savedTracks:]
+	tracks := make([]Track, 0, len(k7.Tracks)+len(added))
+	tracks = append(tracks, k7.Tracks[:saved]...)
+	tracks = append(tracks, added...)
+	k7.Tracks = append(tracks, k7.Tracks[saved:]...)
+	k7.stats.TracksLoaded += len(added)
+
+	return nil
+}
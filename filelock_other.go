@@ -0,0 +1,16 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !windows
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!windows
+
+package govcr
+
+import "os"
+
+// tryLockFile reports ErrFileLockUnsupported: the platform has no advisory file locks.
+func tryLockFile(f *os.File) (bool, error) {
+	return false, ErrFileLockUnsupported
+}
+
+// unlockFile releases the lock acquired by tryLockFile.
+func unlockFile(f *os.File) error {
+	return nil
+}
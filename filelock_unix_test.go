@@ -0,0 +1,89 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package govcr_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/seborama/govcr"
+)
+
+func TestFileLockContention(t *testing.T) {
+	cassettePath := t.TempDir()
+
+	// create a test server
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "Hello")
+	}))
+	defer ts.Close()
+
+	cfg := &govcr.VCRConfig{CassettePath: cassettePath, FileLock: true, FileLockTimeout: 50 * time.Millisecond}
+	vcr, err := govcr.NewVCRE("TestFileLockContention", cfg)
+	if err != nil {
+		t.Fatalf("err from govcr.NewVCRE(): Expected nil, got %s", err)
+	}
+
+	// another process holds the lock of the cassette file
+	f, err := os.OpenFile(filepath.Join(cassettePath, "TestFileLockContention.cassette.lock"), os.O_RDWR|os.O_CREATE, 0640)
+	if err != nil {
+		t.Fatalf("err from os.OpenFile(): Expected nil, got %s", err)
+	}
+	defer f.Close()
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		t.Fatalf("err from syscall.Flock(): Expected nil, got %s", err)
+	}
+
+	if _, err := vcr.Client.Get(ts.URL); !errors.Is(err, govcr.ErrFileLockTimeout) {
+		t.Fatalf("err from vcr.Client.Get(): Expected ErrFileLockTimeout, got %v", err)
+	}
+	if _, err := govcr.NewVCRE("TestFileLockContention", cfg); !errors.Is(err, govcr.ErrFileLockTimeout) {
+		t.Fatalf("err from govcr.NewVCRE(): Expected ErrFileLockTimeout, got %v", err)
+	}
+
+	// the VCR is not blocked while a request waits for the lock
+	cfg.FileLockTimeout = 5 * time.Second
+	syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+	vcr = govcr.NewVCR("TestFileLockContention", cfg)
+	syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+
+	errc := make(chan error)
+	go func() {
+		resp, err := vcr.Client.Get(ts.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		errc <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	countc := make(chan int)
+	go func() { countc <- vcr.LiveCallCount() }()
+	select {
+	case n := <-countc:
+		if n != 1 {
+			t.Fatalf("Expected 1 live call, got %d", n)
+		}
+	case err := <-errc:
+		t.Fatalf("Expected the request to wait for the lock, got %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("Expected LiveCallCount() not to wait for the lock")
+	}
+
+	// once the lock is released, the track is recorded
+	syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+	if err := <-errc; err != nil {
+		t.Fatalf("err from vcr.Client.Get(): Expected nil, got %s", err)
+	}
+	if n := len(govcr.NewVCR("TestFileLockContention", cfg).Cassette().Tracks); n != 1 {
+		t.Fatalf("Expected 1 track, got %d", n)
+	}
+}
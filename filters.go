@@ -0,0 +1,469 @@
+package govcr
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// ResponseTemplateData is the data supplied to templates created by ResponseBodyTemplate.
+type ResponseTemplateData struct {
+	Request struct {
+		Header http.Header
+	}
+	Response struct {
+		Header http.Header
+		Body   string
+	}
+}
+
+// ResponseBodyTemplate returns a ResponseFilterFunc that replaces the body of the replayed
+// response with the output of the supplied text/template.
+//
+// The template is executed against a ResponseTemplateData which gives access to the header
+// of the Request and to the recorded header / body of the Response. For instance:
+//
+//	{"orderId": "{{.Request.Header.Get "X-Order-Id"}}"}
+//
+// An error is returned if the template cannot be parsed. Should the template fail to execute
+// at replay time, the response body is left untouched.
+func ResponseBodyTemplate(tmpl string) (ResponseFilterFunc, error) {
+	t, err := template.New("govcr").Parse(tmpl)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(respHdr http.Header, body []byte, reqHdr http.Header) (*http.Header, *[]byte) {
+		var data ResponseTemplateData
+		data.Request.Header = reqHdr
+		data.Response.Header = respHdr
+		data.Response.Body = string(body)
+
+		var out bytes.Buffer
+		if err := t.Execute(&out, data); err != nil {
+			return &respHdr, &body
+		}
+
+		newBody := out.Bytes()
+		return &respHdr, &newBody
+	}, nil
+}
+
+// RequestDeleteCookies returns a RequestFilterFunc that removes the named cookies from the
+// Cookie header of the request. All cookies are removed when no name is supplied.
+// The Cookie header is deleted altogether when no cookie remains.
+func RequestDeleteCookies(names ...string) RequestFilterFunc {
+	return func(header http.Header, body []byte) (*http.Header, *[]byte) {
+		newHeader := cloneHeader(header)
+		if newHeader == nil {
+			return &header, &body
+		}
+
+		var kept []string
+		for _, c := range (&http.Request{Header: header}).Cookies() {
+			if len(names) > 0 && !containsString(names, c.Name) {
+				kept = append(kept, c.String())
+			}
+		}
+
+		newHeader.Del("Cookie")
+		if len(kept) > 0 {
+			newHeader.Set("Cookie", strings.Join(kept, "; "))
+		}
+
+		return &newHeader, &body
+	}
+}
+
+// ResponseDeleteCookies returns a ResponseFilterFunc that removes the Set-Cookie headers of
+// the named cookies from the response. All Set-Cookie headers are removed when no name is supplied.
+//
+// Use it as VCRConfig.RecordResponseFilterFunc to keep session tokens off the cassette.
+func ResponseDeleteCookies(names ...string) ResponseFilterFunc {
+	return func(respHdr http.Header, body []byte, reqHdr http.Header) (*http.Header, *[]byte) {
+		newHeader := cloneHeader(respHdr)
+		if newHeader == nil {
+			return &respHdr, &body
+		}
+
+		var kept []string
+		for _, v := range newHeader[http.CanonicalHeaderKey("Set-Cookie")] {
+			if len(names) > 0 && !containsString(names, setCookieName(v)) {
+				kept = append(kept, v)
+			}
+		}
+
+		newHeader.Del("Set-Cookie")
+		if len(kept) > 0 {
+			newHeader[http.CanonicalHeaderKey("Set-Cookie")] = kept
+		}
+
+		return &newHeader, &body
+	}
+}
+
+// ResponseSetContentType returns a ResponseFilterFunc that sets the Content-Type header of the
+// response to contentType. The header returned by a ResponseFilterFunc replaces that of the
+// response, so that a filter can rewrite the body and set its Content-Type in the same pass:
+//
+//	setXML := govcr.ResponseSetContentType("application/xml")
+//	func(respHdr http.Header, body []byte, reqHdr http.Header) (*http.Header, *[]byte) {
+//		xmlBody := toXML(body)
+//		return setXML(respHdr, xmlBody, reqHdr)
+//	}
+func ResponseSetContentType(contentType string) ResponseFilterFunc {
+	return func(respHdr http.Header, body []byte, reqHdr http.Header) (*http.Header, *[]byte) {
+		newHeader := cloneHeader(respHdr)
+		if newHeader == nil {
+			newHeader = http.Header{}
+		}
+		newHeader.Set("Content-Type", contentType)
+
+		return &newHeader, &body
+	}
+}
+
+// setCookieName returns the name of the cookie of a Set-Cookie header value.
+func setCookieName(setCookie string) string {
+	name := setCookie
+	if idx := strings.IndexAny(name, "=;"); idx != -1 {
+		name = name[:idx]
+	}
+
+	return strings.TrimSpace(name)
+}
+
+// FreezeJSONTimeOption is an option of RequestFreezeJSONTime.
+type FreezeJSONTimeOption int
+
+const (
+	// FreezeCreateMissing creates the field (and its missing parent objects) when it is absent.
+	FreezeCreateMissing FreezeJSONTimeOption = iota + 1
+)
+
+// RequestFreezeJSONTime returns a RequestFilterFunc that sets the field at path (a JSONPath
+// expression, see VCRConfig.JSONPathMatch) of JSON request bodies to the string value, i.e. the
+// timestamp of a request body:
+//
+//	RequestFilterFunc: govcr.RequestFreezeJSONTime("$.meta.sentAt", "2000-01-01T00:00:00Z")
+//
+// By default, bodies in which the field is absent are left untouched. With FreezeCreateMissing,
+// the field is created. Bodies that are not JSON are left untouched.
+// The filtered body is re-encoded, which does not affect matching since the filter applies to
+// both the track and the request.
+func RequestFreezeJSONTime(path, value string, options ...FreezeJSONTimeOption) RequestFilterFunc {
+	create := false
+	for _, o := range options {
+		if o == FreezeCreateMissing {
+			create = true
+		}
+	}
+
+	return func(header http.Header, body []byte) (*http.Header, *[]byte) {
+		var doc interface{}
+		if err := decodeJSON(body, &doc); err != nil {
+			return &header, &body
+		}
+
+		doc, ok := setJSONPathValue(doc, path, value, create)
+		if !ok {
+			return &header, &body
+		}
+
+		newBody, err := json.Marshal(doc)
+		if err != nil {
+			return &header, &body
+		}
+
+		return &header, &newBody
+	}
+}
+
+// GraphQLRequestFilter returns a RequestFilterFunc that canonicalises GraphQL request bodies
+// (a JSON object with "query", "variables" and "operationName") for the purpose of matching.
+// The "operationName" and "variables" are compared regardless of the ordering of the keys and
+// of formatting, and the whitespace in "query" is ignored.
+// Bodies that are not GraphQL requests are left untouched.
+//
+// This is useful for GraphQL endpoints where all requests are POST'ed to the same URL.
+func GraphQLRequestFilter() RequestFilterFunc {
+	return func(header http.Header, body []byte) (*http.Header, *[]byte) {
+		var gql struct {
+			Query         *string     `json:"query"`
+			OperationName string      `json:"operationName"`
+			Variables     interface{} `json:"variables"`
+		}
+
+		dec := json.NewDecoder(bytes.NewReader(body))
+		dec.UseNumber()
+		if err := dec.Decode(&gql); err != nil || gql.Query == nil {
+			return &header, &body
+		}
+
+		canonical, err := json.Marshal(map[string]interface{}{
+			"operationName": gql.OperationName,
+			"query":         strings.Join(strings.Fields(*gql.Query), " "),
+			"variables":     gql.Variables,
+		})
+		if err != nil {
+			return &header, &body
+		}
+
+		return &header, &canonical
+	}
+}
+
+// canonicalMultipartBoundary is the boundary of the bodies re-encoded by RequestCanonicalMultipart.
+const canonicalMultipartBoundary = "govcr-canonical-multipart-boundary"
+
+// RequestCanonicalMultipart returns a RequestFilterFunc that canonicalises multipart/form-data
+// request bodies for the purpose of matching: the parts are sorted by form name (then file name)
+// and re-encoded with a fixed boundary, which the Content-Type header is updated with. The content
+// of file parts is replaced with its hex encoded SHA-256 digest.
+// Two uploads that hold the same fields in a different order thus compare equal.
+// Bodies that are not multipart/form-data (or cannot be parsed) are left untouched.
+func RequestCanonicalMultipart() RequestFilterFunc {
+	return func(header http.Header, body []byte) (*http.Header, *[]byte) {
+		mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+		if err != nil || mediaType != "multipart/form-data" || params["boundary"] == "" {
+			return &header, &body
+		}
+
+		type part struct {
+			header  textproto.MIMEHeader
+			content []byte
+		}
+
+		var parts []part
+		r := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+		for {
+			p, err := r.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return &header, &body
+			}
+
+			content, err := ioutil.ReadAll(p)
+			if err != nil {
+				return &header, &body
+			}
+			if p.FileName() != "" {
+				content = []byte(hashBody(content))
+			}
+			parts = append(parts, part{header: p.Header, content: content})
+		}
+
+		sort.SliceStable(parts, func(i, j int) bool {
+			ni, nj := formPartName(parts[i].header), formPartName(parts[j].header)
+			if ni[0] != nj[0] {
+				return ni[0] < nj[0]
+			}
+			return ni[1] < nj[1]
+		})
+
+		var out bytes.Buffer
+		w := multipart.NewWriter(&out)
+		if err := w.SetBoundary(canonicalMultipartBoundary); err != nil {
+			return &header, &body
+		}
+		for _, p := range parts {
+			pw, err := w.CreatePart(p.header)
+			if err != nil {
+				return &header, &body
+			}
+			pw.Write(p.content)
+		}
+		if err := w.Close(); err != nil {
+			return &header, &body
+		}
+
+		newHeader := cloneHeader(header)
+		newHeader.Set("Content-Type", w.FormDataContentType())
+		newBody := out.Bytes()
+
+		return &newHeader, &newBody
+	}
+}
+
+// formPartName returns the form name and the file name of a multipart part.
+func formPartName(header textproto.MIMEHeader) [2]string {
+	_, params, err := mime.ParseMediaType(header.Get("Content-Disposition"))
+	if err != nil {
+		return [2]string{}
+	}
+
+	return [2]string{params["name"], params["filename"]}
+}
+
+// uuidRegexp matches UUIDs in their canonical textual form.
+var uuidRegexp = regexp.MustCompile(`(?i)\b[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}\b`)
+
+// UUIDTarget selects the parts of a request or response in which UUIDs are replaced.
+// See RequestReplaceUUIDs.
+type UUIDTarget int
+
+const (
+	// UUIDsInHeader replaces the UUIDs in the header values.
+	UUIDsInHeader UUIDTarget = 1 << iota
+
+	// UUIDsInBody replaces the UUIDs in the body.
+	UUIDsInBody
+)
+
+// RequestReplaceUUIDs returns a RequestFilterFunc that replaces the UUIDs of the request (i.e.
+// "123e4567-e89b-12d3-a456-426614174000") with replacement, so that requests match although
+// they embed identifiers that change on every run.
+// By default, the UUIDs are replaced in both the header values and the body. This can be
+// restricted by supplying targets.
+//
+// Note that the URL is not supplied to filter functions: set RequestURLReplaceUUIDs as
+// VCRConfig.RequestURLFilterFunc to replace the UUIDs of the URL too, or on its own for the URL
+// only.
+func RequestReplaceUUIDs(replacement string, targets ...UUIDTarget) RequestFilterFunc {
+	inHeader, inBody := uuidTargets(targets)
+
+	return func(header http.Header, body []byte) (*http.Header, *[]byte) {
+		if inHeader {
+			header = replaceUUIDsInHeader(header, replacement)
+		}
+		if inBody {
+			body = uuidRegexp.ReplaceAll(body, []byte(replacement))
+		}
+
+		return &header, &body
+	}
+}
+
+// RequestURLReplaceUUIDs returns a URLFilterFunc that replaces the UUIDs of the path and of the
+// query of the request URL with replacement. See RequestReplaceUUIDs.
+func RequestURLReplaceUUIDs(replacement string) URLFilterFunc {
+	return func(u *url.URL) {
+		u.Path = uuidRegexp.ReplaceAllString(u.Path, replacement)
+		u.RawPath = uuidRegexp.ReplaceAllString(u.RawPath, replacement)
+		u.RawQuery = uuidRegexp.ReplaceAllString(u.RawQuery, replacement)
+	}
+}
+
+// ResponseReplaceUUIDs returns a ResponseFilterFunc that replaces the UUIDs of the response with
+// replacement. See RequestReplaceUUIDs.
+func ResponseReplaceUUIDs(replacement string, targets ...UUIDTarget) ResponseFilterFunc {
+	inHeader, inBody := uuidTargets(targets)
+
+	return func(respHdr http.Header, body []byte, reqHdr http.Header) (*http.Header, *[]byte) {
+		if inHeader {
+			respHdr = replaceUUIDsInHeader(respHdr, replacement)
+		}
+		if inBody {
+			body = uuidRegexp.ReplaceAll(body, []byte(replacement))
+		}
+
+		return &respHdr, &body
+	}
+}
+
+// uuidTargets indicates whether UUIDs are to be replaced in the header and in the body.
+func uuidTargets(targets []UUIDTarget) (inHeader bool, inBody bool) {
+	if len(targets) == 0 {
+		return true, true
+	}
+
+	var t UUIDTarget
+	for _, target := range targets {
+		t |= target
+	}
+
+	return t&UUIDsInHeader != 0, t&UUIDsInBody != 0
+}
+
+// replaceUUIDsInHeader returns a copy of the header with the UUIDs of its values replaced.
+func replaceUUIDsInHeader(header http.Header, replacement string) http.Header {
+	newHeader := cloneHeader(header)
+	for _, val := range newHeader {
+		for i, v := range val {
+			val[i] = uuidRegexp.ReplaceAllString(v, replacement)
+		}
+	}
+
+	return newHeader
+}
+
+// RewriteHostOption is an option of ResponseRewriteHost.
+type RewriteHostOption int
+
+const (
+	// RewriteURLEncoded also rewrites the URL encoded occurrences of the host
+	// (i.e. "http%3A%2F%2Fexample.com").
+	RewriteURLEncoded RewriteHostOption = iota + 1
+)
+
+// ResponseRewriteHost returns a ResponseFilterFunc that replaces the occurrences of oldHost in
+// the body of the response with newHost. This fixes the absolute links to the original host
+// (i.e. "127.0.0.1:54321", the random port of a test server) held by recorded bodies, as found
+// with HATEOAS style APIs.
+// The hosts may include the scheme (i.e. "http://127.0.0.1:54321") to restrict the rewrite to URLs.
+func ResponseRewriteHost(oldHost, newHost string, options ...RewriteHostOption) ResponseFilterFunc {
+	replacements := [][2][]byte{{[]byte(oldHost), []byte(newHost)}}
+	for _, o := range options {
+		if o == RewriteURLEncoded {
+			replacements = append(replacements, [2][]byte{[]byte(url.QueryEscape(oldHost)), []byte(url.QueryEscape(newHost))})
+		}
+	}
+
+	return func(respHdr http.Header, body []byte, reqHdr http.Header) (*http.Header, *[]byte) {
+		if oldHost == "" {
+			return &respHdr, &body
+		}
+
+		for _, r := range replacements {
+			body = bytes.ReplaceAll(body, r[0], r[1])
+		}
+
+		return &respHdr, &body
+	}
+}
+
+// ResponseRewriteLinkHeader returns a ResponseFilterFunc that replaces the occurrences of oldHost
+// in the Link headers of the response with newHost, i.e. to follow the rel="next" links of a
+// paginated API, recorded against the random port of a test server, on playback.
+// Each value of a multi-value Link header is rewritten in place: the values and their order are
+// preserved. As with ResponseRewriteHost, the hosts may include the scheme.
+func ResponseRewriteLinkHeader(oldHost, newHost string) ResponseFilterFunc {
+	return func(respHdr http.Header, body []byte, reqHdr http.Header) (*http.Header, *[]byte) {
+		links := respHdr[http.CanonicalHeaderKey("Link")]
+		if oldHost == "" || len(links) == 0 {
+			return &respHdr, &body
+		}
+
+		newHeader := cloneHeader(respHdr)
+		rewritten := make([]string, len(links))
+		for i, v := range links {
+			rewritten[i] = strings.ReplaceAll(v, oldHost, newHost)
+		}
+		newHeader[http.CanonicalHeaderKey("Link")] = rewritten
+
+		return &newHeader, &body
+	}
+}
+
+// containsString indicates whether s is one of values.
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,281 @@
+package govcr_test
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/seborama/govcr"
+)
+
+func TestResponseBodyTemplate(t *testing.T) {
+	if _, err := govcr.ResponseBodyTemplate("{{.Request.Header"); err == nil {
+		t.Fatalf("err from govcr.ResponseBodyTemplate(): Expected an error, got nil")
+	}
+
+	filter, err := govcr.ResponseBodyTemplate(`{"orderId":"{{.Request.Header.Get "X-Order-Id"}}","was":{{.Response.Body}}}`)
+	if err != nil {
+		t.Fatalf("err from govcr.ResponseBodyTemplate(): Expected nil, got %s", err)
+	}
+
+	reqHdr := http.Header{}
+	reqHdr.Set("X-Order-Id", "1234")
+
+	_, body := filter(http.Header{}, []byte(`"recorded"`), reqHdr)
+
+	expectedBody := `{"orderId":"1234","was":"recorded"}`
+	if string(*body) != expectedBody {
+		t.Fatalf("Body: expected '%s', got '%s'", expectedBody, *body)
+	}
+}
+
+func TestRequestDeleteCookies(t *testing.T) {
+	header := http.Header{}
+	header.Set("Cookie", "session=abc; theme=dark; lang=en")
+
+	newHeader, _ := govcr.RequestDeleteCookies("session", "lang")(header, nil)
+	if newHeader.Get("Cookie") != "theme=dark" {
+		t.Fatalf("Cookie: expected 'theme=dark', got '%s'", newHeader.Get("Cookie"))
+	}
+	if header.Get("Cookie") != "session=abc; theme=dark; lang=en" {
+		t.Fatalf("Expected the original header to be left untouched, got '%s'", header.Get("Cookie"))
+	}
+
+	newHeader, _ = govcr.RequestDeleteCookies()(header, nil)
+	if _, ok := (*newHeader)["Cookie"]; ok {
+		t.Fatalf("Expected the Cookie header to be removed, got '%s'", newHeader.Get("Cookie"))
+	}
+}
+
+func TestResponseDeleteCookies(t *testing.T) {
+	cassetteName := "TestResponseDeleteCookies"
+
+	// create a test server
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Set-Cookie", "session=secret; HttpOnly")
+		w.Header().Add("Set-Cookie", "theme=dark")
+		w.Header().Add("Set-Cookie", "token=secret")
+	}))
+	defer ts.Close()
+
+	if err := govcr.DeleteCassette(cassetteName, ""); err != nil {
+		t.Fatalf("err from govcr.DeleteCassette(): Expected nil, got %s", err)
+	}
+
+	cfg := &govcr.VCRConfig{RecordResponseFilterFunc: govcr.ResponseDeleteCookies("session", "token")}
+
+	// the live response is untouched
+	vcr := govcr.NewVCR(cassetteName, cfg)
+	resp, err := vcr.Client.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("err from vcr.Client.Get(): Expected nil, got %s", err)
+	}
+	if len(resp.Header["Set-Cookie"]) != 3 {
+		t.Fatalf("Expected 3 Set-Cookie headers, got %v", resp.Header["Set-Cookie"])
+	}
+
+	// the recorded response is not
+	vcr = govcr.NewVCR(cassetteName, cfg)
+	resp, err = vcr.Client.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("err from vcr.Client.Get(): Expected nil, got %s", err)
+	}
+	if len(resp.Header["Set-Cookie"]) != 1 || resp.Header.Get("Set-Cookie") != "theme=dark" {
+		t.Fatalf("Expected only Set-Cookie 'theme=dark', got %v", resp.Header["Set-Cookie"])
+	}
+	checkStats(t, vcr.Stats(), 1, 0, 1)
+}
+
+func TestGraphQLRequestFilter(t *testing.T) {
+	filter := govcr.GraphQLRequestFilter()
+
+	_, body1 := filter(http.Header{}, []byte(`{"query":"query GetUser($id: ID!) {\n  user(id: $id) { name }\n}","operationName":"GetUser","variables":{"id":"1","opts":{"a":1,"b":2}}}`))
+	_, body2 := filter(http.Header{}, []byte(`{"variables":{"opts":{"b":2,"a":1},"id":"1"},"operationName":"GetUser","query":"query GetUser($id: ID!) { user(id: $id) { name } }"}`))
+	if string(*body1) != string(*body2) {
+		t.Fatalf("Expected equivalent GraphQL requests to be equal, got '%s' and '%s'", *body1, *body2)
+	}
+
+	_, body3 := filter(http.Header{}, []byte(`{"query":"query GetUser($id: ID!) { user(id: $id) { name } }","operationName":"GetUser","variables":{"id":"2"}}`))
+	if string(*body1) == string(*body3) {
+		t.Fatalf("Expected GraphQL requests with different variables to differ, got '%s'", *body3)
+	}
+
+	_, body4 := filter(http.Header{}, []byte(`not json`))
+	if string(*body4) != "not json" {
+		t.Fatalf("Expected a non-GraphQL body to be left untouched, got '%s'", *body4)
+	}
+}
+
+func TestRequestCanonicalMultipart(t *testing.T) {
+	filter := govcr.RequestCanonicalMultipart()
+
+	upload := func(fields ...[2]string) (http.Header, []byte) {
+		var body bytes.Buffer
+		w := multipart.NewWriter(&body)
+		for _, f := range fields {
+			if f[0] == "file" {
+				fw, _ := w.CreateFormFile(f[0], "a.txt")
+				fw.Write([]byte(f[1]))
+				continue
+			}
+			w.WriteField(f[0], f[1])
+		}
+		w.Close()
+
+		header := http.Header{}
+		header.Set("Content-Type", w.FormDataContentType())
+		return header, body.Bytes()
+	}
+
+	header1, body1 := filter(upload([2]string{"b", "2"}, [2]string{"file", "content"}, [2]string{"a", "1"}))
+	header2, body2 := filter(upload([2]string{"a", "1"}, [2]string{"b", "2"}, [2]string{"file", "content"}))
+	if string(*body1) != string(*body2) || header1.Get("Content-Type") != header2.Get("Content-Type") {
+		t.Fatalf("Expected equivalent uploads to be equal, got '%s' (%s) and '%s' (%s)", *body1, header1.Get("Content-Type"), *body2, header2.Get("Content-Type"))
+	}
+	if bytes.Contains(*body1, []byte("content")) {
+		t.Fatalf("Expected the file part to be hashed, got '%s'", *body1)
+	}
+
+	_, body3 := filter(upload([2]string{"a", "1"}, [2]string{"b", "3"}, [2]string{"file", "content"}))
+	if string(*body1) == string(*body3) {
+		t.Fatalf("Expected uploads with different fields to differ, got '%s'", *body3)
+	}
+
+	_, body4 := filter(upload([2]string{"a", "1"}, [2]string{"b", "2"}, [2]string{"file", "other"}))
+	if string(*body1) == string(*body4) {
+		t.Fatalf("Expected uploads with different files to differ, got '%s'", *body4)
+	}
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	_, body5 := filter(header, []byte(`{"a":1}`))
+	if string(*body5) != `{"a":1}` {
+		t.Fatalf("Expected a non-multipart body to be left untouched, got '%s'", *body5)
+	}
+}
+
+func TestRequestFreezeJSONTime(t *testing.T) {
+	filter := govcr.RequestFreezeJSONTime("$.meta.sentAt", "2000-01-01T00:00:00Z")
+
+	_, body1 := filter(http.Header{}, []byte(`{"id":1,"meta":{"sentAt":"2019-03-14T09:26:53Z"}}`))
+	_, body2 := filter(http.Header{}, []byte(`{"meta":{"sentAt":"2020-06-01T12:00:00Z"},"id":1}`))
+	if string(*body1) != `{"id":1,"meta":{"sentAt":"2000-01-01T00:00:00Z"}}` || string(*body1) != string(*body2) {
+		t.Fatalf("Expected the timestamps to be frozen, got '%s' and '%s'", *body1, *body2)
+	}
+
+	_, body3 := filter(http.Header{}, []byte(`{"id":1}`))
+	if string(*body3) != `{"id":1}` {
+		t.Fatalf("Expected a body without the field to be left untouched, got '%s'", *body3)
+	}
+
+	_, body4 := govcr.RequestFreezeJSONTime("$.meta.sentAt", "2000-01-01T00:00:00Z", govcr.FreezeCreateMissing)(http.Header{}, []byte(`{"id":1}`))
+	if string(*body4) != `{"id":1,"meta":{"sentAt":"2000-01-01T00:00:00Z"}}` {
+		t.Fatalf("Expected the field to be created, got '%s'", *body4)
+	}
+
+	_, body5 := govcr.RequestFreezeJSONTime("$.items[1].at", "0")(http.Header{}, []byte(`{"items":[{"at":"1"},{"at":"2"}]}`))
+	if string(*body5) != `{"items":[{"at":"1"},{"at":"0"}]}` {
+		t.Fatalf("Expected the array element to be frozen, got '%s'", *body5)
+	}
+
+	_, body6 := filter(http.Header{}, []byte(`not json`))
+	if string(*body6) != "not json" {
+		t.Fatalf("Expected a non-JSON body to be left untouched, got '%s'", *body6)
+	}
+}
+
+func TestReplaceUUIDs(t *testing.T) {
+	header := http.Header{}
+	header.Set("X-Request-Id", "123e4567-e89b-12d3-a456-426614174000")
+	body := []byte(`{"id":"123E4567-E89B-12D3-A456-426614174000","n":"123e4567"}`)
+
+	newHeader, newBody := govcr.RequestReplaceUUIDs("<uuid>")(header, body)
+	if newHeader.Get("X-Request-Id") != "<uuid>" {
+		t.Fatalf("X-Request-Id: expected '<uuid>', got '%s'", newHeader.Get("X-Request-Id"))
+	}
+	if string(*newBody) != `{"id":"<uuid>","n":"123e4567"}` {
+		t.Fatalf("Body: expected the UUID to be replaced, got '%s'", *newBody)
+	}
+	if header.Get("X-Request-Id") != "123e4567-e89b-12d3-a456-426614174000" {
+		t.Fatalf("Expected the original header to be left untouched, got '%s'", header.Get("X-Request-Id"))
+	}
+
+	newHeader, newBody = govcr.ResponseReplaceUUIDs("<uuid>", govcr.UUIDsInBody)(header, body, nil)
+	if newHeader.Get("X-Request-Id") != "123e4567-e89b-12d3-a456-426614174000" {
+		t.Fatalf("Expected the header to be left untouched, got '%s'", newHeader.Get("X-Request-Id"))
+	}
+	if string(*newBody) != `{"id":"<uuid>","n":"123e4567"}` {
+		t.Fatalf("Body: expected the UUID to be replaced, got '%s'", *newBody)
+	}
+
+	newHeader, newBody = govcr.RequestReplaceUUIDs("<uuid>", govcr.UUIDsInHeader)(header, body)
+	if newHeader.Get("X-Request-Id") != "<uuid>" || string(*newBody) != string(body) {
+		t.Fatalf("Expected the header only to be changed, got '%s' and '%s'", newHeader.Get("X-Request-Id"), *newBody)
+	}
+
+	u, err := url.Parse("http://example.com/users/123e4567-e89b-12d3-a456-426614174000?ref=123E4567-E89B-12D3-A456-426614174000&n=123e4567")
+	if err != nil {
+		t.Fatalf("err from url.Parse(): Expected nil, got %s", err)
+	}
+	govcr.RequestURLReplaceUUIDs("uuid")(u)
+	if u.String() != "http://example.com/users/uuid?ref=uuid&n=123e4567" {
+		t.Fatalf("URL: expected the UUIDs to be replaced, got '%s'", u)
+	}
+}
+
+func TestResponseRewriteHost(t *testing.T) {
+	body := []byte(`{"self":"http://127.0.0.1:1234/users/1","next":"/login?to=http%3A%2F%2F127.0.0.1%3A1234%2Fusers%2F2"}`)
+
+	_, newBody := govcr.ResponseRewriteHost("http://127.0.0.1:1234", "http://127.0.0.1:5678")(http.Header{}, body, nil)
+	expected := `{"self":"http://127.0.0.1:5678/users/1","next":"/login?to=http%3A%2F%2F127.0.0.1%3A1234%2Fusers%2F2"}`
+	if string(*newBody) != expected {
+		t.Fatalf("Body: expected '%s', got '%s'", expected, *newBody)
+	}
+
+	_, newBody = govcr.ResponseRewriteHost("http://127.0.0.1:1234", "http://127.0.0.1:5678", govcr.RewriteURLEncoded)(http.Header{}, body, nil)
+	expected = `{"self":"http://127.0.0.1:5678/users/1","next":"/login?to=http%3A%2F%2F127.0.0.1%3A5678%2Fusers%2F2"}`
+	if string(*newBody) != expected {
+		t.Fatalf("Body: expected '%s', got '%s'", expected, *newBody)
+	}
+}
+
+func TestResponseRewriteLinkHeader(t *testing.T) {
+	cassetteName := "TestResponseRewriteLinkHeader"
+
+	// create a test server
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Link", "<http://"+r.Host+`/users?page=2>; rel="next"`)
+		w.Header().Add("Link", "<http://"+r.Host+`/users?page=9>; rel="last"`)
+	}))
+	defer ts.Close()
+
+	if err := govcr.DeleteCassette(cassetteName, ""); err != nil {
+		t.Fatalf("err from govcr.DeleteCassette(): Expected nil, got %s", err)
+	}
+
+	vcr := govcr.NewVCR(cassetteName, nil)
+	if _, err := vcr.Client.Get(ts.URL + "/users"); err != nil {
+		t.Fatalf("err from vcr.Client.Get(): Expected nil, got %s", err)
+	}
+
+	// both Link headers are played back, rewritten
+	newURL := "http://127.0.0.1:5678"
+	vcr = govcr.NewVCR(cassetteName, &govcr.VCRConfig{ResponseFilterFunc: govcr.ResponseRewriteLinkHeader(ts.URL, newURL)})
+	resp, err := vcr.Client.Get(ts.URL + "/users")
+	if err != nil {
+		t.Fatalf("err from vcr.Client.Get(): Expected nil, got %s", err)
+	}
+	if vcr.Stats().TracksPlayed != 1 {
+		t.Fatalf("Expected 1 track played, got %d", vcr.Stats().TracksPlayed)
+	}
+	expected := []string{
+		"<" + newURL + `/users?page=2>; rel="next"`,
+		"<" + newURL + `/users?page=9>; rel="last"`,
+	}
+	if links := resp.Header["Link"]; len(links) != 2 || links[0] != expected[0] || links[1] != expected[1] {
+		t.Fatalf("Link: expected %q, got %q", expected, links)
+	}
+}
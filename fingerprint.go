@@ -0,0 +1,61 @@
+package govcr
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// fingerprint returns the hex encoded HMAC-SHA256, keyed with VCRConfig.FingerprintSalt, of the
+// parts of the request that take part in matching: the method, the URL, the host (with
+// VCRConfig.MatchHost), the normalised header and the canonical body, after RequestFilterFunc
+// has been applied. See VCRConfig.MatchFingerprintOnly.
+func (pcbr *pcb) fingerprint(req *http.Request) (string, error) {
+	bodyData, err := readRequestBody(req)
+	if err != nil {
+		return "", err
+	}
+
+	filteredHeader, filteredBody := pcbr.RequestFilterFunc(req.Header, bodyData)
+	header := pcbr.normaliseHeader(*filteredHeader)
+
+	mac := hmac.New(sha256.New, []byte(pcbr.FingerprintSalt))
+	write := func(s string) {
+		// the length prefix keeps the fields apart
+		fmt.Fprintf(mac, "%d:%s", len(s), s)
+	}
+
+	write(req.Method)
+	urlStr := ""
+	if req.URL != nil {
+		urlStr = pcbr.matchedURL(req.URL, false)
+	}
+	write(urlStr)
+	if pcbr.MatchHost && !pcbr.MatchPathOnly {
+		write(requestHost(req))
+	}
+
+	keys := make([]string, 0, len(header))
+	for k := range header {
+		if !pcbr.ExcludeHeaderFunc(k) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	write(strconv.Itoa(len(keys)))
+	for _, k := range keys {
+		write(http.CanonicalHeaderKey(k))
+		write(strings.Join(header[k], "\n"))
+	}
+
+	if pcbr.shouldMatchBody(req) {
+		write(string(pcbr.canonicalBody(*filteredHeader, *filteredBody)))
+	}
+
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
@@ -0,0 +1,175 @@
+package govcr
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CassetteFormat is the format of cassette files. See VCRConfig.Format.
+type CassetteFormat int
+
+const (
+	// FormatJSON stores cassettes as indented JSON, in ".cassette" files.
+	// This is the default, which suits code reviews and hand edits.
+	FormatJSON CassetteFormat = iota
+
+	// FormatGob stores cassettes with encoding/gob, in ".cassette.gob" files.
+	// Gob cassettes are faster to load than JSON ones, which matters for large fixture sets.
+	// See ConvertCassetteFile to inspect them.
+	FormatGob
+)
+
+// gobExtension is the extension of cassette files in FormatGob.
+const gobExtension = cassetteExtension + ".gob"
+
+// extension returns the extension of the cassette files in the format.
+func (f CassetteFormat) extension() string {
+	switch f {
+	case FormatGob:
+		return gobExtension
+	case formatTrackFiles:
+		return ""
+	}
+
+	return cassetteExtension
+}
+
+// formatOf returns the format of a cassette file, as per its extension.
+func formatOf(filename string) CassetteFormat {
+	if strings.HasSuffix(filename, gobExtension) {
+		return FormatGob
+	}
+
+	return FormatJSON
+}
+
+// ErrAmbiguousCassetteFormat is the error reported when a cassette is stored both in a
+// ".cassette" and a ".cassette.gob" file.
+var ErrAmbiguousCassetteFormat = errors.New("govcr: cassette exists in both the JSON and the gob formats")
+
+// probeCassetteFormat returns the format of the existing file of the cassette, whichever of
+// FormatJSON and FormatGob the requested format is, so that a cassette is loaded (and saved) in the
+// format it was recorded in. The requested format is returned when there is no such file.
+func probeCassetteFormat(cassetteName, cassettePath string, format CassetteFormat) (CassetteFormat, error) {
+	if format != FormatJSON && format != FormatGob {
+		return format, nil
+	}
+
+	_, jsonErr := os.Stat(cassetteFilename(cassetteName, cassettePath, FormatJSON))
+	_, gobErr := os.Stat(cassetteFilename(cassetteName, cassettePath, FormatGob))
+	switch {
+	case jsonErr == nil && gobErr == nil:
+		return format, fmt.Errorf("%w: %s", ErrAmbiguousCassetteFormat, cassetteFilename(cassetteName, cassettePath, FormatJSON))
+	case jsonErr == nil:
+		return FormatJSON, nil
+	case gobErr == nil:
+		return FormatGob, nil
+	}
+
+	return format, nil
+}
+
+// encode returns the cassette in the format.
+func (k7 *Cassette) encode(format CassetteFormat) ([]byte, error) {
+	if format != FormatGob {
+		return k7.marshal()
+	}
+
+	// the TLS connection states hold interfaces (i.e. the public keys of certificates) that gob
+	// cannot encode without registering each concrete type, hence they are kept in JSON as they
+	// are in FormatJSON
+	gk7 := gobCassette{Cassette: *k7, TLS: make([][]byte, len(k7.Tracks))}
+	gk7.Cassette.Tracks = append([]Track(nil), k7.Tracks...)
+	for i := range gk7.Cassette.Tracks {
+		track := &gk7.Cassette.Tracks[i]
+		if track.Response.TLS == nil {
+			continue
+		}
+
+		data, err := json.Marshal(track.Response.TLS)
+		if err != nil {
+			return nil, err
+		}
+		if gk7.TLS[i], err = transformInterfacesInJSON(data); err != nil {
+			return nil, err
+		}
+		track.Response.TLS = nil
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(gk7); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// gobCassette is the form of a cassette in FormatGob.
+type gobCassette struct {
+	Cassette Cassette
+
+	// TLS holds the JSON encoded TLS connection state of the response of each track, if any.
+	TLS [][]byte
+}
+
+// decodeCassette decodes a cassette from data in the format.
+func decodeCassette(data []byte, format CassetteFormat) (*Cassette, error) {
+	if format != FormatGob {
+		return unmarshalCassette(data)
+	}
+
+	var gk7 gobCassette
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&gk7); err != nil {
+		return nil, err
+	}
+
+	cassette := &gk7.Cassette
+	for i, tlsData := range gk7.TLS {
+		if tlsData == nil || i >= len(cassette.Tracks) {
+			continue
+		}
+
+		cassette.Tracks[i].Response.TLS = &tls.ConnectionState{}
+		if err := json.Unmarshal(tlsData, cassette.Tracks[i].Response.TLS); err != nil {
+			return nil, err
+		}
+	}
+
+	cassette.size = int64(len(data))
+
+	return cassette, nil
+}
+
+// ConvertCassetteFile converts the cassette file src to dst. The formats of the files are
+// detected from their extensions: ".cassette.gob" for FormatGob and ".cassette" for FormatJSON.
+// This permits inspecting a binary cassette (i.e. ConvertCassetteFile("a.cassette.gob",
+// "a.cassette")) or converting an existing fixture set to FormatGob.
+func ConvertCassetteFile(src, dst string) error {
+	data, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+
+	k7, err := decodeCassette(data, formatOf(src))
+	if err != nil {
+		return err
+	}
+
+	if data, err = k7.encode(formatOf(dst)); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0750); err != nil {
+		return err
+	}
+
+	return writeFileAtomic(dst, data, "")
+}
@@ -0,0 +1,235 @@
+package govcr
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// GenerateGoFixtures writes to w the Go source of a file of package pkg that declares a function
+// returning the tracks of the cassette, i.e. to inline the critical fixtures of a test rather than
+// ship cassette files:
+//
+//	// svcUsersTracks returns the tracks of cassette "svc/users".
+//	func svcUsersTracks() []govcr.Track {
+//		return []govcr.Track{ ... }
+//	}
+//
+// The name of the function is derived from the name of the cassette. The tracks are reconstructed
+// with their request, response, error, sequence key and variant. The TLS connection states and the
+// timings are not. The tracks can be looked up with Cassette.Find, i.e. on
+// &govcr.Cassette{Tracks: svcUsersTracks()}, and their responses re-created with
+// Track.HTTPResponse.
+// Only JSON cassettes are considered.
+func GenerateGoFixtures(cassetteName, cassettePath, pkg string, w io.Writer) error {
+	k7, err := readCassetteFromFile(cassetteName, cassettePath)
+	if err != nil {
+		return err
+	}
+
+	g := &goFixtureGenerator{}
+	g.printf("return []govcr.Track{\n")
+	for _, t := range k7.Tracks {
+		g.track(t)
+	}
+	g.printf("}\n")
+
+	var src bytes.Buffer
+	fmt.Fprintf(&src, "// Code generated by govcr.GenerateGoFixtures. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&src, "package %s\n\nimport (\n", pkg)
+	if g.usesHTTP {
+		fmt.Fprintf(&src, "%q\n", "net/http")
+	}
+	if g.usesURL {
+		fmt.Fprintf(&src, "%q\n", "net/url")
+	}
+	fmt.Fprintf(&src, "\n%q\n)\n\n", "github.com/seborama/govcr")
+
+	funcName := goFixtureFuncName(cassetteName)
+	fmt.Fprintf(&src, "// %s returns the tracks of cassette %q.\n", funcName, cassetteName)
+	fmt.Fprintf(&src, "func %s() []govcr.Track {\n%s}\n", funcName, g.buf.Bytes())
+
+	formatted, err := format.Source(src.Bytes())
+	if err != nil {
+		return fmt.Errorf("govcr: cassette '%s': %w", cassetteName, err)
+	}
+
+	_, err = w.Write(formatted)
+	return err
+}
+
+// goFixtureGenerator writes the Go literals of tracks.
+type goFixtureGenerator struct {
+	buf bytes.Buffer
+
+	// usesHTTP and usesURL indicate whether the literals refer to the packages net/http and
+	// net/url, which are then imported.
+	usesHTTP, usesURL bool
+}
+
+func (g *goFixtureGenerator) printf(format string, a ...interface{}) {
+	fmt.Fprintf(&g.buf, format, a...)
+}
+
+// field writes the field of a struct literal, unless its value is the zero value.
+func (g *goFixtureGenerator) field(name string, value interface{}) {
+	switch v := value.(type) {
+	case string:
+		if v != "" {
+			g.printf("%s: %s,\n", name, strconv.Quote(v))
+		}
+	case int:
+		if v != 0 {
+			g.printf("%s: %d,\n", name, v)
+		}
+	case int64:
+		if v != 0 {
+			g.printf("%s: %d,\n", name, v)
+		}
+	case bool:
+		if v {
+			g.printf("%s: true,\n", name)
+		}
+	case []byte:
+		if v != nil {
+			g.printf("%s: []byte(%s),\n", name, strconv.Quote(string(v)))
+		}
+	case []string:
+		if v != nil {
+			g.printf("%s: %s,\n", name, goStrings(v))
+		}
+	case http.Header:
+		g.header(name, v)
+	case *url.URL:
+		g.url(name, v)
+	}
+}
+
+func (g *goFixtureGenerator) track(t Track) {
+	g.printf("{\n")
+
+	g.printf("Request: govcr.Request{\n")
+	g.field("Method", t.Request.Method)
+	g.field("URL", t.Request.URL)
+	g.field("Header", t.Request.Header)
+	g.field("Body", t.Request.Body)
+	g.field("Fingerprint", t.Request.Fingerprint)
+	g.field("BodyHash", t.Request.BodyHash)
+	g.field("Host", t.Request.Host)
+	g.field("Raw", t.Request.Raw)
+	g.printf("},\n")
+
+	g.printf("Response: govcr.Response{\n")
+	g.field("Status", t.Response.Status)
+	g.field("StatusCode", t.Response.StatusCode)
+	g.field("Proto", t.Response.Proto)
+	g.field("ProtoMajor", t.Response.ProtoMajor)
+	g.field("ProtoMinor", t.Response.ProtoMinor)
+	g.field("Header", t.Response.Header)
+	g.field("Body", t.Response.Body)
+	g.field("ContentLength", t.Response.ContentLength)
+	g.field("TransferEncoding", t.Response.TransferEncoding)
+	g.field("Trailer", t.Response.Trailer)
+	g.field("BodySkipped", t.Response.BodySkipped)
+	g.printf("},\n")
+
+	g.field("ErrType", t.ErrType)
+	g.field("ErrMsg", t.ErrMsg)
+	g.field("RemoteAddr", t.RemoteAddr)
+	g.field("SequenceKey", t.SequenceKey)
+	g.field("Variant", t.Variant)
+
+	g.printf("},\n")
+}
+
+// header writes a header field, with its keys sorted.
+func (g *goFixtureGenerator) header(name string, hdr http.Header) {
+	if hdr == nil {
+		return
+	}
+	g.usesHTTP = true
+
+	keys := make([]string, 0, len(hdr))
+	for k := range hdr {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	g.printf("%s: http.Header{\n", name)
+	for _, k := range keys {
+		g.printf("%s: %s,\n", strconv.Quote(k), goStrings(hdr[k]))
+	}
+	g.printf("},\n")
+}
+
+// url writes a URL field.
+func (g *goFixtureGenerator) url(name string, u *url.URL) {
+	if u == nil {
+		return
+	}
+	g.usesURL = true
+
+	g.printf("%s: &url.URL{\n", name)
+	g.field("Scheme", u.Scheme)
+	g.field("Opaque", u.Opaque)
+	if u.User != nil {
+		if password, ok := u.User.Password(); ok {
+			g.printf("User: url.UserPassword(%s, %s),\n", strconv.Quote(u.User.Username()), strconv.Quote(password))
+		} else {
+			g.printf("User: url.User(%s),\n", strconv.Quote(u.User.Username()))
+		}
+	}
+	g.field("Host", u.Host)
+	g.field("Path", u.Path)
+	g.field("RawPath", u.RawPath)
+	g.field("ForceQuery", u.ForceQuery)
+	g.field("RawQuery", u.RawQuery)
+	g.field("Fragment", u.Fragment)
+	g.field("RawFragment", u.RawFragment)
+	g.printf("},\n")
+}
+
+// goStrings returns the Go literal of a slice of strings.
+func goStrings(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = strconv.Quote(v)
+	}
+
+	return "[]string{" + strings.Join(quoted, ", ") + "}"
+}
+
+// goFixtureFuncName returns the name of the function generated by GenerateGoFixtures for a
+// cassette: the camel case of the letters and digits of its name, i.e. "svcUsersTracks" for
+// "svc/users".
+func goFixtureFuncName(cassetteName string) string {
+	var b strings.Builder
+	upper := false
+	for _, r := range cassetteName {
+		switch {
+		case !unicode.IsLetter(r) && !unicode.IsDigit(r):
+			upper = b.Len() > 0
+		case b.Len() == 0 && unicode.IsDigit(r):
+			// an identifier cannot start with a digit
+			b.WriteString("cassette")
+			b.WriteRune(r)
+			upper = false
+		case b.Len() == 0:
+			b.WriteRune(unicode.ToLower(r))
+		case upper:
+			b.WriteRune(unicode.ToUpper(r))
+			upper = false
+		default:
+			b.WriteRune(r)
+		}
+	}
+
+	return b.String() + "Tracks"
+}
@@ -0,0 +1,59 @@
+package govcr
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ErrGoldenMismatch is the error reported when VCRConfig.GoldenMode is enabled and the live
+// response differs from the recorded one.
+var ErrGoldenMismatch = errors.New("govcr: the live response differs from the golden track")
+
+// verifyGolden executes the request live and compares the response with that of the track, as
+// per VCRConfig.GoldenMode. An error that wraps ErrGoldenMismatch and describes the differences
+// is returned when they differ.
+func (t *vcrTransport) verifyGolden(cassette *Cassette, trackNumber int, req *http.Request, liveReq *http.Request) error {
+	t.mu.Lock()
+	err := t.countLiveCall(req)
+	golden := cassette.Tracks[trackNumber]
+	t.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	t.PCB.Logger.Printf("INFO - Cassette '%s' - Verifying track #%d against the live server for %s %s\n", cassette.Name, trackNumber, req.Method, req.URL.String())
+
+	resp, httpErr := t.PCB.Transport.RoundTrip(liveReq)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+
+	// the live response goes through the same steps as when it is recorded
+	live, err := t.PCB.newRecordedTrack(req, resp, httpErr, nil)
+	if err != nil {
+		return err
+	}
+
+	diff := newTrackDiff(TrackChanged, trackNumber, &golden, -1, live)
+	if diff.StatusCodes[0] == diff.StatusCodes[1] && diff.Errors[0] == diff.Errors[1] && diff.Body == nil {
+		return nil
+	}
+
+	report := []string{fmt.Sprintf("cassette '%s' - track #%d (%s %s)", cassette.Name, trackNumber, req.Method, req.URL.String())}
+	if diff.StatusCodes[0] != diff.StatusCodes[1] {
+		report = append(report, fmt.Sprintf("status code: recorded %d, live %d", diff.StatusCodes[0], diff.StatusCodes[1]))
+	}
+	if diff.Errors[0] != diff.Errors[1] {
+		report = append(report, fmt.Sprintf("error: recorded '%s', live '%s'", diff.Errors[0], diff.Errors[1]))
+	}
+	if diff.Body != nil {
+		report = append(report, "body (- recorded, + live):")
+		for _, l := range diff.Body {
+			report = append(report, string(l.Op)+" "+l.Line)
+		}
+	}
+
+	return fmt.Errorf("%w: %s", ErrGoldenMismatch, strings.Join(report, "\n\t"))
+}
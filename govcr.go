@@ -2,12 +2,27 @@ package govcr
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash/fnv"
 	"io"
 	"io/ioutil"
 	"log"
+	"math"
+	"math/rand"
 	"net/http"
+	"net/url"
 	"os"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // VCRControlPanel holds the parts of a VCR that can be interacted with.
@@ -16,12 +31,270 @@ type VCRControlPanel struct {
 	Client *http.Client
 }
 
+// ReplayOrderMatchesRecording indicates whether the tracks have been played back in the order
+// they were recorded so far. Tracks that have not been played back are not taken into account.
+func (vcr *VCRControlPanel) ReplayOrderMatchesRecording() bool {
+	vcrT := vcr.Client.Transport.(*vcrTransport)
+
+	vcrT.mu.Lock()
+	defer vcrT.mu.Unlock()
+
+	return !vcrT.outOfOrder
+}
+
+// CassettePath returns the absolute path of the file of the cassette supplied to NewVCR, or of its
+// directory with VCRConfig.OneFilePerTrack.
+func (vcr *VCRControlPanel) CassettePath() string {
+	vcrT := vcr.Client.Transport.(*vcrTransport)
+	return vcrT.Cassette.filename()
+}
+
+// LiveCallCount returns the number of requests that the VCR has executed live.
+func (vcr *VCRControlPanel) LiveCallCount() int {
+	vcrT := vcr.Client.Transport.(*vcrTransport)
+
+	vcrT.mu.Lock()
+	defer vcrT.mu.Unlock()
+
+	return vcrT.liveCalls
+}
+
+// Now returns the time of the cassette: a clock frozen at the time VCRConfig.Now returned when
+// the VCR was created, which is saved on the cassette with the tracks once Now has been called. The code
+// under test and the filters that derive fields from the current time (i.e. a "date" query
+// parameter) should read this clock, so that it yields the same time during recording and on
+// replay. Filters, which are configured before the VCR is created, can capture the VCR:
+//
+//	var vcr *govcr.VCRControlPanel
+//	cfg.RequestFilterFunc = func(header http.Header, body []byte) (*http.Header, *[]byte) {
+//		today := vcr.Now().Format("2006-01-02")
+//		...
+//	}
+//	vcr = govcr.NewVCR("MyCassette", cfg)
+func (vcr *VCRControlPanel) Now() time.Time {
+	vcrT := vcr.Client.Transport.(*vcrTransport)
+
+	// Now does not take vcrT.mu, which is held while the filters run
+	atomic.StoreInt32(&vcrT.nowRead, 1)
+
+	return vcrT.now
+}
+
+// HTTPClient returns the HTTP client associated with the VCR.
+// All of its methods (Do, Get, Head, Post and PostForm) record and play back through the VCR.
+// Copies of the client do too, as long as they keep its Transport.
+func (vcr *VCRControlPanel) HTTPClient() *http.Client {
+	return vcr.Client
+}
+
 // Stats returns Stats about the cassette and VCR session.
+// When VCRConfig.CassetteRouter is set, these are the Stats of the cassette supplied to NewVCR.
 func (vcr *VCRControlPanel) Stats() Stats {
 	vcrT := vcr.Client.Transport.(*vcrTransport)
 	return vcrT.Cassette.Stats()
 }
 
+// ExhaustedTracksPolicy defines the behaviour of the VCR when all of the tracks that match
+// a request have already been replayed.
+type ExhaustedTracksPolicy int
+
+const (
+	// ExhaustedTracksLive executes the request live (and records it unless recording is disabled).
+	ExhaustedTracksLive ExhaustedTracksPolicy = iota
+
+	// ExhaustedTracksRepeatLast replays the last matching track again.
+	ExhaustedTracksRepeatLast
+
+	// ExhaustedTracksError fails the request with ErrTracksExhausted.
+	ExhaustedTracksError
+)
+
+// ErrMaxLiveCalls is the error reported when a request would exceed VCRConfig.MaxLiveCalls.
+var ErrMaxLiveCalls = errors.New("govcr: maximum number of live calls reached")
+
+// ErrOutOfOrder is the error reported when VCRConfig.EnforceOrder is set and a request matches a
+// track that was recorded before the last track played back.
+var ErrOutOfOrder = errors.New("govcr: request replayed out of recorded order")
+
+// DuplicateTracksPolicy defines how the tracks of a cassette that match the same request are
+// dealt with when the cassette is loaded.
+type DuplicateTracksPolicy int
+
+const (
+	// DupKeepAll keeps all tracks. Duplicates are replayed in the order they were recorded.
+	DupKeepAll DuplicateTracksPolicy = iota
+
+	// DupKeepFirst keeps the first of the duplicate tracks only.
+	DupKeepFirst
+
+	// DupKeepLast keeps the last of the duplicate tracks only.
+	DupKeepLast
+
+	// DupError fails loading the cassette with ErrDuplicateTrack.
+	DupError
+)
+
+// ErrDuplicateTrack is the error reported when a cassette contains tracks that match the same
+// request and VCRConfig.OnDuplicateTrack is DupError.
+var ErrDuplicateTrack = errors.New("govcr: cassette contains duplicate tracks")
+
+// ErrTracksExhausted is the error returned when all of the tracks that match a request have
+// already been replayed and VCRConfig.ExhaustedTracks is ExhaustedTracksError.
+var ErrTracksExhausted = errors.New("govcr: all matching tracks have already been replayed")
+
+// ErrVCRClosed is the error returned for the requests made after VCRControlPanel.Close.
+var ErrVCRClosed = errors.New("govcr: the VCR is closed")
+
+// TestingT is the subset of testing.T used by Verify and AssertNoLiveCalls to report failures.
+type TestingT interface {
+	Errorf(format string, args ...interface{})
+}
+
+// Verify reports, through t, the tracks of the cassette that were not played back as well as
+// the requests for which no track matched.
+// It is intended to be called at the end of a test (i.e. t.Cleanup(func() { vcr.Verify(t) }))
+// and turns the cassette into a strict contract.
+// The cassettes selected by VCRConfig.CassetteRouter are verified too.
+func (vcr *VCRControlPanel) Verify(t TestingT) {
+	vcrT := vcr.Client.Transport.(*vcrTransport)
+
+	vcrT.mu.Lock()
+	defer vcrT.mu.Unlock()
+
+	for _, cassette := range vcrT.cassettes() {
+		for idx, track := range cassette.Tracks {
+			if !track.replayed {
+				t.Errorf("govcr: cassette '%s' - track #%d (%s %s) was not used", cassette.Name, idx, track.Request.Method, track.Request.URL)
+			}
+		}
+	}
+
+	for _, miss := range vcrT.misses {
+		t.Errorf("govcr: cassette '%s' - no track matched %s", vcrT.Cassette.Name, miss)
+	}
+}
+
+// AssertNoLiveCalls reports, through t, a failure that lists the requests executed live when
+// LiveCallCount is not zero. It guarantees that the test was fully served by the cassette
+// (i.e. t.Cleanup(func() { vcr.AssertNoLiveCalls(t) })) and pairs well with
+// VCRConfig.DisableRecording.
+func (vcr *VCRControlPanel) AssertNoLiveCalls(t TestingT) {
+	vcrT := vcr.Client.Transport.(*vcrTransport)
+
+	vcrT.mu.Lock()
+	defer vcrT.mu.Unlock()
+
+	if vcrT.liveCalls == 0 {
+		return
+	}
+
+	t.Errorf("govcr: cassette '%s' - %d request(s) went live:\n\t%s", vcrT.Cassette.Name, vcrT.liveCalls, strings.Join(vcrT.liveURLs, "\n\t"))
+}
+
+// Compact removes the tracks of the cassette that have not been replayed (nor recorded) by this
+// VCR and saves the cassette.
+//
+// Only call Compact after a complete and successful run of the tests that use the cassette,
+// typically behind a flag (i.e. "go test -compact"): tracks that are needed by tests that did not
+// run would otherwise be lost.
+// The cassettes selected by VCRConfig.CassetteRouter are compacted too.
+func (vcr *VCRControlPanel) Compact() error {
+	vcrT := vcr.Client.Transport.(*vcrTransport)
+
+	vcrT.mu.Lock()
+	defer vcrT.mu.Unlock()
+
+	for _, cassette := range vcrT.cassettes() {
+		if cassette.compact() == 0 {
+			continue
+		}
+
+		if err := cassette.save(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Close saves the cassettes that the VCR recorded tracks to and calls VCRConfig.OnClose for each
+// cassette opened by the VCR. The tracks are saved as they are recorded, so that Close is only
+// required with OnClose, i.e. with a deferred call or t.Cleanup. The requests made after Close
+// fail with ErrVCRClosed. Calling Close again has no effect.
+func (vcr *VCRControlPanel) Close() error {
+	vcrT := vcr.Client.Transport.(*vcrTransport)
+
+	vcrT.mu.Lock()
+
+	if vcrT.closed {
+		vcrT.mu.Unlock()
+		return nil
+	}
+	vcrT.closed = true
+
+	var (
+		firstErr error
+		names    []string
+	)
+	for _, cassette := range vcrT.cassettes() {
+		if cassette.tracksRecorded() > 0 {
+			if err := cassette.save(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		names = append(names, cassette.Name)
+	}
+
+	vcrT.mu.Unlock()
+
+	// the hooks are called without the lock, so that they can use the VCR (i.e. its Stats)
+	if vcrT.onClose != nil {
+		for _, name := range names {
+			vcrT.onClose(name)
+		}
+	}
+
+	return firstErr
+}
+
+// Cassette returns the cassette supplied to NewVCR, i.e. to look up its tracks with Find.
+func (vcr *VCRControlPanel) Cassette() *Cassette {
+	return vcr.Client.Transport.(*vcrTransport).Cassette
+}
+
+// Match returns the track of the cassette that would be played back for the request, were it
+// made with the VCR's Client.
+// This is a pure lookup: the track is neither replayed nor marked as such, no request is executed
+// and no ResponseFilterFunc is run. The Stats are not affected.
+// The RequestFilterFunc and the other matching options apply as they do for playback, including
+// VCRConfig.ExhaustedTracks and VCRConfig.BestMatch.
+func (vcr *VCRControlPanel) Match(req *http.Request) (*Track, bool) {
+	vcrT := vcr.Client.Transport.(*vcrTransport)
+
+	copiedReq, err := copyRequest(req)
+	if err != nil {
+		vcrT.PCB.Logger.Println(err)
+		return nil, false
+	}
+
+	vcrT.mu.Lock()
+	defer vcrT.mu.Unlock()
+
+	cassette, err := vcrT.cassetteFor(copiedReq)
+	if err != nil {
+		vcrT.PCB.Logger.Println(err)
+		return nil, false
+	}
+
+	trackNumber, err := vcrT.matchTrack(cassette, copiedReq, false, false)
+	if err != nil || trackNumber == trackNotFound {
+		return nil, false
+	}
+
+	track := cassette.Tracks[trackNumber]
+	return &track, true
+}
+
 const defaultCassettePath = "./govcr-fixtures/"
 
 // VCRConfig holds a set of options for the VCR.
@@ -34,28 +307,478 @@ type VCRConfig struct {
 	// This is useful when a fingerprint is exchanged and expected to match between request and response.
 	ResponseFilterFunc ResponseFilterFunc
 
+	// RecordResponseFilterFunc can be used to modify the header / body of the response before it is
+	// saved on the cassette (i.e. to remove secrets). The live response is returned untouched.
+	RecordResponseFilterFunc ResponseFilterFunc
+
 	DisableRecording bool
 	Logging          bool
 	CassettePath     string
+
+	// PreserveHeaderCase replays the response header keys exactly as recorded on the cassette.
+	// By default, header keys are canonicalised (see http.CanonicalHeaderKey) on replay.
+	// Note that http.Transport canonicalises the keys it reads from the wire, so this only
+	// keeps the case of keys that reach govcr non-canonical, i.e. from a custom Transport or
+	// a hand-edited cassette.
+	PreserveHeaderCase bool
+
+	// HashRequestBodies stores a SHA-256 digest of the request body on the track instead of
+	// the body itself. Matching then compares digests. The response body is recorded in full.
+	// The digest is computed after RequestFilterFunc has been applied.
+	HashRequestBodies bool
+
+	// SkipBodyContentTypes lists the response content types (i.e. "image/png" or "video/")
+	// whose body should not be recorded. The headers and status are recorded as normal and
+	// the response is replayed with an empty body.
+	// A content type matches when the response's Content-Type starts with it (case-insensitive).
+	SkipBodyContentTypes []string
+
+	// RequireCassetteExists makes NewVCR fail (and NewVCRE return ErrCassetteNotFound) when the
+	// cassette file does not exist, rather than starting with an empty cassette.
+	// This is useful for replay-only workflows to catch mistyped cassette names early.
+	RequireCassetteExists bool
+
+	// AuthSchemeMatch reduces the Authorization header of requests to its scheme (i.e. "Bearer")
+	// for the purpose of matching. Tracks then match regardless of the token (or credentials)
+	// while authenticated requests still do not match unauthenticated tracks.
+	// The Authorization header is recorded in full.
+	AuthSchemeMatch bool
+
+	// RecoverFilterPanics recovers from panics in RequestFilterFunc, ResponseFilterFunc and
+	// RecordResponseFilterFunc.
+	// The panic is logged and the header / body are used unfiltered.
+	RecoverFilterPanics bool
+
+	// MaxRecordedTracks is the maximum number of new tracks recorded by the VCR.
+	// Once reached, live requests are still executed but no longer recorded.
+	// Zero means unlimited.
+	MaxRecordedTracks int
+
+	// ShouldMatchBody decides, per request, whether the request body is compared with that of the
+	// tracks. This permits restricting body matching to the requests that need it (i.e. POST /graphql).
+	// When nil, the body is always compared, whatever the method (including GET requests with a body).
+	ShouldMatchBody func(req *http.Request) bool
+
+	// Rand is a source of random data for use by filter functions (i.e. to generate identifiers).
+	// When nil, NewVCR sets it to a pseudo-random source seeded from the cassette name so that the
+	// generated values are identical between recording and replay.
+	// Filters can read from it by capturing the VCRConfig, i.e. io.ReadFull(vcrConfig.Rand, buf).
+	Rand io.Reader
+
+	// ExhaustedTracks defines what happens to a request when all of the tracks that match it have
+	// already been replayed (i.e. a request repeated more times than it was recorded).
+	// By default (ExhaustedTracksLive), the request is executed live.
+	ExhaustedTracks ExhaustedTracksPolicy
+
+	// TempDir is the directory where cassette files are written before being renamed into place.
+	// It defaults to the directory of the cassette, which ensures the rename stays on the same filesystem.
+	// The save fails when the rename fails, i.e. when TempDir is on another filesystem.
+	TempDir string
+
+	// OnDuplicateTrack defines how the tracks of the cassette that match the same request are dealt
+	// with when the cassette is loaded. Tracks are compared with the same rules as for replay.
+	// By default (DupKeepAll), all tracks are kept and duplicates are replayed in the order they were
+	// recorded. With DupError, NewVCRE returns ErrDuplicateTrack (NewVCR exits).
+	OnDuplicateTrack DuplicateTracksPolicy
+
+	// PathTemplate is a URL path template such as "/v1/users/{id}/posts/{pid}" that is used when
+	// matching requests: the segments in braces match any value. The concrete path is recorded.
+	// Paths that do not follow the template are compared as usual.
+	PathTemplate string
+
+	// RequestURLFilterFunc filters the URLs of the requests and of the tracks when matching,
+	// before IgnorePathVersion and PathTemplate apply, i.e. RequestURLReplaceUUIDs.
+	// The concrete URL is recorded.
+	RequestURLFilterFunc URLFilterFunc
+
+	// RecordRequestHeaders is an allowlist of the request headers that are saved on the cassette.
+	// Only these headers take part in matching. When empty, all request headers are recorded.
+	// A name that ends with "*" is a prefix (i.e. "X-*").
+	RecordRequestHeaders []string
+
+	// CassetteRouter returns the name of the cassette that the request is recorded on and replayed
+	// from. This permits keeping the tracks of different services on separate cassettes while using a
+	// single client. An empty name selects the cassette supplied to NewVCR.
+	// The routed cassettes are loaded on first use and then kept in memory for the lifetime of the VCR,
+	// so that each cassette file is read once only.
+	CassetteRouter func(req *http.Request) string
+
+	// MatchRawPath makes path based matching (i.e. PathTemplate) operate on the escaped form of the
+	// URL path (URL.EscapedPath()) rather than on the decoded URL.Path, so that an encoded slash ("%2F")
+	// is not taken for a path separator.
+	// Note that URLs are always compared in their escaped form: "/a%2Fb" never matches "/a/b".
+	MatchRawPath bool
+
+	// MatchHost makes the host the request is sent to (i.e. the Host header) take part in matching,
+	// in addition to the URL. This distinguishes tracks that differ only by Host header, as is the
+	// case with virtual hosting. Tracks recorded by older versions of govcr carry no host and match
+	// regardless. ServerHandler ignores MatchHost.
+	MatchHost bool
+
+	// HeaderOrderInsensitive makes the comparison of request header values insensitive to their
+	// order, whether they are supplied as a comma separated list (i.e. "Accept: a, b") or as several
+	// values of the same header. By default, the comparison is order-sensitive and only considers the
+	// first value of each header.
+	HeaderOrderInsensitive bool
+
+	// EnforceOrder fails requests with ErrOutOfOrder when they match a track that was recorded
+	// before the last track played back from the cassette. This validates the sequencing of stateful
+	// flows (i.e. begin / commit transactions).
+	// Concurrent requests arrive in no particular order and should not be used with EnforceOrder,
+	// unless each worker uses its own sequence key (see WithSequenceKey): the order is then
+	// enforced per key. See also VCRControlPanel.ReplayOrderMatchesRecording.
+	EnforceOrder bool
+
+	// FrozenTime, when set, replaces the value of the time headers of the responses recorded on the
+	// cassette (see FreezeTimeHeaders) at record time, which keeps the cassette stable across
+	// recordings. The live response is untouched.
+	FrozenTime time.Time
+
+	// FreezeTimeHeaders lists the response headers that FrozenTime applies to.
+	// It defaults to "Date".
+	FreezeTimeHeaders []string
+
+	// RecordIf is consulted before a new track is saved on the cassette: when it returns false, the
+	// live response is returned to the client but it is not recorded. It receives the response as it
+	// would be recorded, that is after RecordResponseFilterFunc. The response is empty when the live
+	// request failed.
+	RecordIf func(resp Response) bool
+
+	// ReplayLatency delays the responses played back from the cassette, which simulates the network
+	// for load tests and benchmarks. The delay honours the cancellation of the request's context.
+	ReplayLatency time.Duration
+
+	// ReplayJitter adds a random variation within [-ReplayJitter, +ReplayJitter] to ReplayLatency.
+	// The variation is drawn from Rand so that it is reproducible (note that this consumes data
+	// from Rand, for every track played back). The delay is never negative.
+	ReplayJitter time.Duration
+
+	// LatencyFunc returns the delay of each response played back from the cassette, in place of
+	// ReplayLatency and ReplayJitter, i.e. to draw realistic latencies from a distribution
+	// (see NormalLatency). The delay honours the cancellation of the request's context.
+	LatencyFunc func(req Request) time.Duration
+
+	// XMLBodyMatch compares XML request bodies regardless of insignificant whitespace between elements
+	// and of the order of attributes. Bodies that are not both XML are compared exactly.
+	XMLBodyMatch bool
+
+	// StrictHEAD replays the responses to HEAD requests with an empty body, regardless of what was
+	// recorded from a misbehaving server. The Content-Length is left as recorded since, for HEAD
+	// requests, it is that of the corresponding GET response.
+	StrictHEAD bool
+
+	// MatchTrace logs, for each request that matches no track, why it does not match the closest
+	// track (the first one with the same method and URL). Differing bodies are shown as the keys
+	// added, removed and changed when both are JSON, or as a line based diff otherwise.
+	// This is a debugging aid: it costs a diff per miss and requires Logging.
+	MatchTrace bool
+
+	// RecordRawRequest records, on the track, the request line and the headers as written on the
+	// wire by Go's transport (see httputil.DumpRequestOut), which the http.Header map cannot
+	// represent faithfully. This permits byte-accurate assertions. It does not affect matching.
+	RecordRawRequest bool
+
+	// MaxLiveCalls limits the number of requests that the VCR executes live. Beyond it, requests
+	// that match no track fail with ErrMaxLiveCalls, which names the request. This guards against
+	// runaway recordings against rate limited APIs. Zero means no limit.
+	// See also VCRControlPanel.LiveCallCount.
+	MaxLiveCalls int
+
+	// DeduplicateTracks prevents recording a new track for a request that matches a track of the
+	// cassette, which may be the case when a request is repeated more times than it was recorded.
+	// Only the first response of a repeated request is recorded and the cassette never holds
+	// duplicate tracks. The repetitions are executed live, unless ExhaustedTracks is
+	// ExhaustedTracksRepeatLast.
+	DeduplicateTracks bool
+
+	// JSONPathMatch lists JSONPath expressions (i.e. "$.action", "$.resource.id") that replace the
+	// comparison of the request bodies: the bodies match when the values found at each of the paths
+	// are equal. A path that is missing from either body is a miss, as is a body that is not JSON.
+	// Member names ("$.a.b" or "$['a']") and array indices ("$.items[0]") are supported.
+	JSONPathMatch []string
+
+	// MatchFragment makes the URL fragment (i.e. "#section") take part in matching.
+	// Fragments are a client-side concern that is not sent to servers, hence they are ignored by
+	// default.
+	MatchFragment bool
+
+	// IgnoreRangeRequests makes byte-range requests (i.e. "Range: bytes=0-99") match the track of
+	// the same request without range, so that varying ranges do not each need a track. The Range and
+	// If-Range headers are dropped from the request before it is matched, recorded and executed live:
+	// the full representation is returned in response, both live and on playback, as a server that
+	// does not support ranges would.
+	IgnoreRangeRequests bool
+
+	// SynthesizeRangeResponses serves byte-range requests (i.e. "Range: bytes=0-99") with a partial
+	// content response (206) that is sliced from the full response, with the Content-Range and
+	// Content-Length headers set accordingly. Requests for a range beyond the body receive a
+	// 416 Requested Range Not Satisfiable response. This implies IgnoreRangeRequests, so that a single
+	// track with the full response serves all ranges.
+	// Only single ranges are synthesised: requests for several ranges receive the full response.
+	SynthesizeRangeResponses bool
+
+	// KeyFunc maps the name of a cassette, as supplied to NewVCR or returned by CassetteRouter, to the
+	// key that the cassette is stored under, i.e. its file name without the ".cassette" extension.
+	// This decouples test names from the storage layout (i.e. hashed keys). The name is still used in
+	// logs. By default, the key is the name. Note that DeleteCassette and the other package functions
+	// take the key.
+	KeyFunc func(cassetteName string) string
+
+	// PreserveContentLength replays the recorded Content-Length even when ResponseFilterFunc changes
+	// the length of the body. By default, the Content-Length header and http.Response.ContentLength
+	// are updated to the length of the filtered body, so that clients do not wait for missing bytes
+	// or stop short. An unknown length (-1) is left as is.
+	PreserveContentLength bool
+
+	// Observer is notified of every request made through the VCR and of its outcome, i.e. to create
+	// tracing spans around them. See Observer.
+	Observer Observer
+
+	// AutoRespondPreflight responds to CORS preflight requests (OPTIONS requests with an
+	// Access-Control-Request-Method header) with a synthesised 204 No Content that allows the origin,
+	// method and headers requested. Preflight requests are then neither matched, recorded nor
+	// executed live, which keeps cassettes focused on the actual calls. This also applies to
+	// ServerHandler. See PreflightHeaders.
+	AutoRespondPreflight bool
+
+	// PreflightHeaders are set on the responses of AutoRespondPreflight, in place of the permissive
+	// defaults of the same name (i.e. "Access-Control-Allow-Origin").
+	PreflightHeaders http.Header
+
+	// IgnorePathVersion makes URLs that differ only by a leading API version segment in their path
+	// (i.e. "/v1/users" and "/v2/users") match. Recorded tracks keep the concrete version.
+	IgnorePathVersion bool
+
+	// Format is the format that new cassette files are written in. FormatJSON (the default) is
+	// suited to code reviews while FormatGob is faster to load. Existing cassette files are loaded
+	// and saved in the format of their extension, whatever Format is, and loading a cassette fails
+	// with ErrAmbiguousCassetteFormat when it exists in both formats.
+	// See ConvertCassetteFile.
+	Format CassetteFormat
+
+	// ReplayResponseFunc is called with each response played back from the cassette, after
+	// ResponseFilterFunc, and may modify it. Unlike ResponseFilterFunc, it can change the status code,
+	// i.e. to replay a recorded 200 as a 503 and exercise error handling without re-recording.
+	// The Status text follows a changed StatusCode unless it is set too, and the Content-Length
+	// follows a changed Body unless PreserveContentLength is set.
+	ReplayResponseFunc func(req Request, resp *Response)
+
+	// DialRewrites maps hosts (i.e. "api.example.com") or "host:port" addresses to the addresses that
+	// live requests connect to in their place (i.e. "127.0.0.1:8080"), without changing the requests.
+	// This permits recording against a local test server without editing /etc/hosts. The port is kept
+	// when the address has none. DialRewrites requires the transport of VCRConfig.Client to be an
+	// *http.Transport (the default), which is copied. It is irrelevant to playback.
+	DialRewrites map[string]string
+
+	// RecordResponseHeaders is an allowlist of the response headers that are saved on the cassette,
+	// i.e. "Content-Type", "ETag", "X-*" (a name that ends with "*" is a prefix). The other headers
+	// (i.e. "Server") are played back no more. When empty, all response headers are recorded.
+	// The live response is untouched.
+	RecordResponseHeaders []string
+
+	// DropResponseHeaders lists the response headers that are not saved on the cassette, in the same
+	// form as RecordResponseHeaders. It applies after RecordResponseHeaders.
+	DropResponseHeaders []string
+
+	// MatchFingerprintOnly stores a fingerprint of the request on the track in place of the request
+	// itself: a salted hash (HMAC-SHA256 keyed with FingerprintSalt) of the method, URL, header and
+	// body that take part in matching, after RequestFilterFunc has been applied. Matching then
+	// compares fingerprints. The raw request (i.e. an Authorization header or personal data in the
+	// body) is not persisted, which suits cassettes shared across teams.
+	// This makes the requests of the cassette un-introspectable by design: the cassette no longer
+	// tells which request a track answers, ValidateCassette cannot check them and the tracks are not
+	// served by ServerHandler (whose requests have no scheme nor host). Matching is exact on the
+	// normalised request: JSONPathMatch and XMLBodyMatch do not apply to fingerprinted tracks.
+	// Changing the matching options or the salt requires recording the cassette again.
+	MatchFingerprintOnly bool
+
+	// FingerprintSalt is the key of the fingerprints of MatchFingerprintOnly. It prevents the
+	// hashes of guessable values (i.e. short tokens) from being looked up without the salt.
+	FingerprintSalt string
+
+	// BodyCanonicalizers are the canonicalisers of request bodies, keyed by Content-Type prefix
+	// (i.e. "application/json", "application/vnd.acme+"). The canonicaliser of the Content-Type of
+	// the request is applied to the (filtered) bodies of both the request and the track before
+	// matching. When prefixes overlap, the longest matching prefix wins, regardless of case (the
+	// empty prefix is thus a fallback). When no prefix matches, the bodies are compared directly.
+	// Canonicalisers run after RequestFilterFunc and before XMLBodyMatch or JSONPathMatch. They
+	// also apply to the digests of HashRequestBodies, to the fingerprints of MatchFingerprintOnly
+	// and to the bodies compared by MatchTrace. Response bodies do not take part in matching.
+	BodyCanonicalizers map[string]func([]byte) []byte
+
+	// Now is the clock of the VCR, time.Now by default. It is read once for the cassette, when the
+	// VCR is created, and the time is saved on the cassette (see Cassette.RecordedAt) once
+	// VCRControlPanel.Now has been called. On replay, VCRControlPanel.Now returns the saved time
+	// instead.
+	Now func() time.Time
+
+	// RateLimit simulates the rate limiting of a server on playback, i.e. to exercise the backoff of
+	// a client: every RateLimit.Every-th request that matches a track receives a synthesised 429 Too
+	// Many Requests, with a Retry-After header as per RateLimit.RetryAfter, in place of the recorded
+	// response. The track is not consumed, so that the retry of the request plays it back.
+	RateLimit RateLimit
+
+	// OneFilePerTrack stores the cassette as a directory, named after the cassette, that holds a
+	// JSON file per track (i.e. "./govcr-fixtures/MyCassette/3f2a9c0d1e4b5a67.json", named after a
+	// digest of the request) rather than a single file. Recording a track writes its file only and
+	// Compact deletes the files of the tracks removed, which keeps diffs small and free of conflicts
+	// in version control. The fields of the cassette other than its tracks are kept in
+	// "_cassette.json". Matching is unchanged. Format does not apply: the files are in JSON.
+	OneFilePerTrack bool
+
+	// MatchPathOnly matches requests on their method and path (and query, header and body as per
+	// the other options), regardless of the scheme and host of their URL, and of MatchHost. This
+	// permits replaying a cassette against other hosts (i.e. "localhost" in CI and a container name in
+	// development) without rewriting the URLs with filters. The full URL is still recorded.
+	MatchPathOnly bool
+
+	// GoldenMode turns the cassette into a set of golden responses: every request goes live. A
+	// request that matches no track is recorded, as usual, whereas the live response to a request that
+	// matches a track is compared with the recorded one before the latter is played back. The request
+	// then fails with an error that wraps ErrGoldenMismatch and describes the differences of status
+	// code, transport error and body (headers are not compared, since they hold dates and such).
+	// The live response goes through the same steps as when it is recorded (i.e.
+	// RecordResponseFilterFunc) before the comparison, and the live calls count towards MaxLiveCalls.
+	// Since it always goes live, enable it with a flag (i.e. GoldenMode: *verifyFlag) so that other runs
+	// replay offline.
+	GoldenMode bool
+
+	// RedactHeaderValueRegex redacts, at record time, the values of the request and response headers
+	// that match it (i.e. JWTs or card numbers): each match is replaced with "REDACTED" before the
+	// track is saved (including Request.Raw with RecordRawRequest). It is a safety net against the
+	// leakage of secrets into cassettes, on top of the filters that target named headers. Matching
+	// redacts the headers of live requests alike, so that they match the recorded tracks. The live
+	// response is untouched.
+	RedactHeaderValueRegex *regexp.Regexp
+
+	// TrimBodyWhitespace ignores the leading and trailing whitespace (i.e. a final newline) of the
+	// request bodies when they are matched, i.e. for form-encoded or text bodies whose whitespace
+	// differs between runs. It applies after BodyCanonicalizers, and to the digests of
+	// HashRequestBodies and the fingerprints of MatchFingerprintOnly. The body is recorded as is.
+	TrimBodyWhitespace bool
+
+	// CollapseBodyWhitespace also replaces each run of whitespace within the request bodies (spaces,
+	// tabs, newlines) with a single space, with TrimBodyWhitespace.
+	CollapseBodyWhitespace bool
+
+	// OnOpen is called with the name of each cassette the VCR opens (the cassette supplied to NewVCR
+	// and those selected by CassetteRouter), before the cassette is loaded, i.e. to acquire a lock on a
+	// cassette file shared by concurrent processes.
+	OnOpen func(name string)
+
+	// OnClose is called with the name of each cassette opened by the VCR when VCRControlPanel.Close
+	// is called, after the cassette has been saved, i.e. to release the lock acquired by OnOpen. It is
+	// also called when the cassette fails to load after OnOpen.
+	OnClose func(name string)
+
+	// FileLock serialises the access of concurrent processes (i.e. parallel test binaries) to the
+	// cassette file with an OS-level advisory lock: flock on Unix, LockFileEx on Windows, on a ".lock"
+	// file next to the cassette file. The lock is held while the cassette is loaded, and while it is
+	// saved: the tracks that other processes appended to the file in the meantime are then merged in
+	// rather than overwritten. Other changes to the file (i.e. by Compact) are overwritten.
+	// On contention, the VCR waits up to FileLockTimeout and then fails with an error that wraps
+	// ErrFileLockTimeout: NewVCRE returns it (NewVCR exits), and so does the request whose track
+	// could not be saved.
+	// On the platforms without advisory locks, the access fails with ErrFileLockUnsupported.
+	FileLock bool
+
+	// FileLockTimeout is the time to wait for the lock of FileLock, 30 seconds by default.
+	FileLockTimeout time.Duration
+
+	// BestMatch plays back the closest track when no track matches a request exactly, rather than
+	// going live, i.e. for the resilience of demos: the tracks not yet replayed are scored by the parts
+	// of their request that agree with the request (see BestMatchWeights) and the best one is played
+	// back, provided its score reaches BestMatchThreshold. The score is logged and summarised by
+	// Stats.TracksBestMatched and Stats.LowestBestMatchScore. This is opt-in and not meant for strict tests.
+	BestMatch bool
+
+	// BestMatchThreshold is the minimum score, between 0 and 1, of the track played back by
+	// BestMatch. It is 0.75 by default.
+	BestMatchThreshold float64
+
+	// BestMatchWeights are the weights of the parts of the request in the score of BestMatch. The
+	// default weights are used when it is not set.
+	BestMatchWeights MatchWeights
 }
 
 // PCB stands for Printed Circuit Board. It is a structure that holds some
 // facilities that are passed to the VCR machine to modify its internals.
 type pcb struct {
-	Transport          http.RoundTripper
-	ExcludeHeaderFunc  ExcludeHeaderFunc
-	RequestFilterFunc  RequestFilterFunc
-	ResponseFilterFunc ResponseFilterFunc
-	Logger             *log.Logger
-	DisableRecording   bool
-	CassettePath       string
+	Transport                http.RoundTripper
+	ExcludeHeaderFunc        ExcludeHeaderFunc
+	RequestFilterFunc        RequestFilterFunc
+	ResponseFilterFunc       ResponseFilterFunc
+	RecordResponseFilterFunc ResponseFilterFunc
+	Logger                   *log.Logger
+	DisableRecording         bool
+	CassettePath             string
+	PreserveHeaderCase       bool
+	HashRequestBodies        bool
+	SkipBodyContentTypes     []string
+	AuthSchemeMatch          bool
+	MaxRecordedTracks        int
+	ShouldMatchBody          func(req *http.Request) bool
+	ExhaustedTracks          ExhaustedTracksPolicy
+	PathTemplate             string
+	RequestURLFilterFunc     URLFilterFunc
+	RecordRequestHeaders     []string
+	CassetteRouter           func(req *http.Request) string
+	MatchRawPath             bool
+	MatchHost                bool
+	HeaderOrderInsensitive   bool
+	EnforceOrder             bool
+	FrozenTime               time.Time
+	FreezeTimeHeaders        []string
+	RecordIf                 func(resp Response) bool
+	ReplayLatency            time.Duration
+	ReplayJitter             time.Duration
+	Rand                     io.Reader
+	XMLBodyMatch             bool
+	StrictHEAD               bool
+	MatchTrace               bool
+	RecordRawRequest         bool
+	MaxLiveCalls             int
+	DeduplicateTracks        bool
+	JSONPathMatch            []string
+	MatchFragment            bool
+	IgnoreRangeRequests      bool
+	SynthesizeRangeResponses bool
+	PreserveContentLength    bool
+	Observer                 Observer
+	AutoRespondPreflight     bool
+	PreflightHeaders         http.Header
+	LatencyFunc              func(req Request) time.Duration
+	IgnorePathVersion        bool
+	ReplayResponseFunc       func(req Request, resp *Response)
+	RecordResponseHeaders    []string
+	DropResponseHeaders      []string
+	MatchFingerprintOnly     bool
+	FingerprintSalt          string
+	BodyCanonicalizers       map[string]func([]byte) []byte
+	Now                      func() time.Time
+	RateLimit                RateLimit
+	MatchPathOnly            bool
+	GoldenMode               bool
+	RedactHeaderValueRegex   *regexp.Regexp
+	TrimBodyWhitespace       bool
+	CollapseBodyWhitespace   bool
+	BestMatch                bool
+	BestMatchThreshold       float64
+	BestMatchWeights         MatchWeights
 }
 
 const trackNotFound = -1
 
-func (pcbr *pcb) seekTrack(cassette *cassette, req *http.Request) int {
+func (pcbr *pcb) seekTrack(cassette *Cassette, req *http.Request) int {
+	return pcbr.seekTrackByURL(cassette, req, false)
+}
+
+// seekTrackByURL looks for a track that matches the request.
+// When ignoreHost is true, the scheme and host of the URLs are ignored for the comparison.
+// This is the case when the request was received by a server rather than sent by a client.
+func (pcbr *pcb) seekTrackByURL(cassette *Cassette, req *http.Request, ignoreHost bool) int {
 	for idx := range cassette.Tracks {
-		if pcbr.trackMatches(cassette, idx, req) {
+		if !cassette.Tracks[idx].replayed && pcbr.trackMatches(cassette, idx, req, ignoreHost) {
 			pcbr.Logger.Printf("INFO - Cassette '%s' - Found a matching track for %s %s\n", cassette.Name, req.Method, req.URL.String())
 			return idx
 		}
@@ -64,8 +787,73 @@ func (pcbr *pcb) seekTrack(cassette *cassette, req *http.Request) int {
 	return trackNotFound
 }
 
+// removeDuplicateTracks applies the DuplicateTracksPolicy to the tracks of the cassette.
+func (pcbr *pcb) removeDuplicateTracks(cassette *Cassette, policy DuplicateTracksPolicy) error {
+	if policy == DupKeepAll {
+		return nil
+	}
+
+	duplicate := make([]bool, len(cassette.Tracks))
+	for i := range cassette.Tracks {
+		if duplicate[i] {
+			continue
+		}
+
+		for j := i + 1; j < len(cassette.Tracks); j++ {
+			if duplicate[j] || !pcbr.tracksMatch(cassette, i, j) {
+				continue
+			}
+
+			switch policy {
+			case DupError:
+				return fmt.Errorf("%w: cassette '%s' - tracks #%d and #%d (%s %s)", ErrDuplicateTrack, cassette.Name, i, j, cassette.Tracks[i].Request.Method, cassette.Tracks[i].Request.URL)
+			case DupKeepFirst:
+				duplicate[j] = true
+			case DupKeepLast:
+				duplicate[i] = true
+			}
+
+			if duplicate[i] {
+				break
+			}
+		}
+	}
+
+	tracks := cassette.Tracks[:0]
+	for i, t := range cassette.Tracks {
+		if duplicate[i] {
+			pcbr.Logger.Printf("INFO - Cassette '%s' - Removing duplicate track #%d (%s %s)\n", cassette.Name, i, t.Request.Method, t.Request.URL)
+			continue
+		}
+		tracks = append(tracks, t)
+	}
+	cassette.Tracks = tracks
+	cassette.stats.TracksLoaded = len(tracks)
+
+	return nil
+}
+
+// tracksMatch indicates whether the tracks i and j of the cassette match the same request, with
+// the same sequence key and variant.
+func (pcbr *pcb) tracksMatch(cassette *Cassette, i, j int) bool {
+	return pcbr.sameRequest(cassette.Tracks[i], cassette, j)
+}
+
+// seekExhaustedTrack looks for the last track that matches the request among those that have
+// already been replayed.
+func (pcbr *pcb) seekExhaustedTrack(cassette *Cassette, req *http.Request, ignoreHost bool) int {
+	for idx := len(cassette.Tracks) - 1; idx >= 0; idx-- {
+		if cassette.Tracks[idx].replayed && pcbr.trackMatches(cassette, idx, req, ignoreHost) {
+			return idx
+		}
+	}
+
+	return trackNotFound
+}
+
 // Matches checks whether the track is a match for the supplied request.
-func (pcbr *pcb) trackMatches(cassette *cassette, trackNumber int, req *http.Request) bool {
+// It does not consider whether the track has already been replayed.
+func (pcbr *pcb) trackMatches(cassette *Cassette, trackNumber int, req *http.Request, ignoreHost bool) bool {
 	if req == nil {
 		return false
 	}
@@ -79,16 +867,343 @@ func (pcbr *pcb) trackMatches(cassette *cassette, trackNumber int, req *http.Req
 
 	track := cassette.Tracks[trackNumber]
 
+	key := sequenceKey(req.Context())
+
+	if track.Request.Fingerprint != "" {
+		// the fingerprint holds the host, unless MatchPathOnly is set
+		if ignoreHost && !pcbr.MatchPathOnly {
+			return false
+		}
+		fingerprint, err := pcbr.fingerprint(req)
+		if err != nil {
+			pcbr.Logger.Println(err)
+			return false
+		}
+		return (key == "" || track.SequenceKey == key) &&
+			track.Variant == variant(req.Context()) &&
+			track.Request.Fingerprint == fingerprint
+	}
+
+	ignoreHost = ignoreHost || pcbr.MatchPathOnly
+
 	// apply filter function to track header / body
 	filteredTrackHeader, filteredTrackBody := pcbr.RequestFilterFunc(track.Request.Header, track.Request.Body)
 	// apply filter function to request header / body
 	filteredReqHeader, filteredReqBody := pcbr.RequestFilterFunc(req.Header, bodyData)
 
-	return !track.replayed &&
+	// the Content-Type of the request selects the canonicaliser of both bodies, as the header
+	// of the track may not hold it (see RecordRequestHeaders)
+	trackBody := pcbr.canonicalBody(*filteredReqHeader, *filteredTrackBody)
+	reqBody := pcbr.canonicalBody(*filteredReqHeader, *filteredReqBody)
+
+	return (key == "" || track.SequenceKey == key) &&
+		track.Variant == variant(req.Context()) &&
 		track.Request.Method == req.Method &&
-		track.Request.URL.String() == req.URL.String() &&
-		pcbr.headerResembles(*filteredTrackHeader, *filteredReqHeader) &&
-		pcbr.bodyResembles(*filteredTrackBody, *filteredReqBody)
+		pcbr.urlResembles(track.Request.URL, req.URL, ignoreHost) &&
+		(ignoreHost || !pcbr.MatchHost || track.Request.Host == "" || track.Request.Host == requestHost(req)) &&
+		pcbr.headerResembles(pcbr.normaliseHeader(*filteredTrackHeader), pcbr.normaliseHeader(*filteredReqHeader)) &&
+		(!pcbr.shouldMatchBody(req) || pcbr.trackBodyResembles(track, trackBody, reqBody))
+}
+
+// canonicalBody applies the canonicaliser of VCRConfig.BodyCanonicalizers that matches the
+// Content-Type of the header to the body, then TrimBodyWhitespace.
+func (pcbr *pcb) canonicalBody(header http.Header, body []byte) []byte {
+	if canonicalizer := pcbr.bodyCanonicalizer(header); canonicalizer != nil {
+		body = canonicalizer(body)
+	}
+
+	if pcbr.TrimBodyWhitespace {
+		body = trimBodyWhitespace(body, pcbr.CollapseBodyWhitespace)
+	}
+
+	return body
+}
+
+// bodyCanonicalizer returns the canonicaliser of VCRConfig.BodyCanonicalizers that matches the
+// Content-Type of the header, if any. The longest matching prefix wins.
+func (pcbr *pcb) bodyCanonicalizer(header http.Header) func([]byte) []byte {
+	if len(pcbr.BodyCanonicalizers) == 0 {
+		return nil
+	}
+
+	contentType := strings.ToLower(GetFirstValue(header, "Content-Type"))
+
+	var (
+		canonicalizer func([]byte) []byte
+		longest       = -1
+	)
+	for prefix, fn := range pcbr.BodyCanonicalizers {
+		if len(prefix) > longest && strings.HasPrefix(contentType, strings.ToLower(prefix)) {
+			canonicalizer, longest = fn, len(prefix)
+		}
+	}
+
+	return canonicalizer
+}
+
+// trimBodyWhitespace returns the body without its leading and trailing whitespace and, with
+// collapse, with each run of whitespace within it replaced by a single space.
+func trimBodyWhitespace(body []byte, collapse bool) []byte {
+	if !collapse {
+		return bytes.TrimSpace(body)
+	}
+
+	return bytes.Join(bytes.Fields(body), []byte(" "))
+}
+
+// requestHost returns the host the request is sent to.
+func requestHost(req *http.Request) string {
+	if req.Host != "" || req.URL == nil {
+		return req.Host
+	}
+
+	return req.URL.Host
+}
+
+// shouldMatchBody indicates whether the body of the request takes part in matching.
+func (pcbr *pcb) shouldMatchBody(req *http.Request) bool {
+	return pcbr.ShouldMatchBody == nil || pcbr.ShouldMatchBody(req)
+}
+
+// trackBodyResembles compares the body of a track with that of a request.
+// When the track only holds a digest of its body, the digests are compared instead.
+func (pcbr *pcb) trackBodyResembles(track Track, filteredTrackBody []byte, filteredReqBody []byte) bool {
+	if track.Request.BodyHash != "" {
+		return track.Request.BodyHash == hashBody(filteredReqBody)
+	}
+
+	if len(pcbr.JSONPathMatch) > 0 {
+		return jsonPathResembles(pcbr.JSONPathMatch, filteredTrackBody, filteredReqBody)
+	}
+
+	return pcbr.bodyResembles(filteredTrackBody, filteredReqBody)
+}
+
+// normaliseHeader returns the form of a request header that is used for matching.
+// The supplied header is not modified.
+func (pcbr *pcb) normaliseHeader(hdr http.Header) http.Header {
+	normalised := pcbr.recordedRequestHeader(hdr)
+
+	if pcbr.IgnoreRangeRequests {
+		normalised = withoutRangeHeaders(normalised)
+	}
+
+	if pcbr.AuthSchemeMatch {
+		normalised = cloneHeader(normalised)
+		for k, val := range normalised {
+			if http.CanonicalHeaderKey(k) != "Authorization" {
+				continue
+			}
+			for i, v := range val {
+				val[i] = authScheme(v)
+			}
+		}
+	}
+
+	if pcbr.HeaderOrderInsensitive {
+		sorted := http.Header{}
+		for k, val := range normalised {
+			var values []string
+			for _, v := range val {
+				for _, item := range strings.Split(v, ",") {
+					values = append(values, strings.TrimSpace(item))
+				}
+			}
+			sort.Strings(values)
+			sorted[k] = []string{strings.Join(values, ", ")}
+		}
+		normalised = sorted
+	}
+
+	return normalised
+}
+
+// rangeHeaders are the request headers of byte-range requests.
+var rangeHeaders = []string{"Range", "If-Range"}
+
+// withoutRangeHeaders returns a copy of the header without the headers of byte-range requests.
+func withoutRangeHeaders(hdr http.Header) http.Header {
+	newHeader := cloneHeader(hdr)
+	for _, h := range rangeHeaders {
+		newHeader.Del(h)
+	}
+
+	return newHeader
+}
+
+// recordedRequestHeader returns the part of a request header that is saved on the cassette, as
+// per RecordRequestHeaders. The supplied header is not modified.
+func (pcbr *pcb) recordedRequestHeader(hdr http.Header) http.Header {
+	if len(pcbr.RecordRequestHeaders) == 0 || hdr == nil {
+		return pcbr.redactHeaderValues(hdr)
+	}
+
+	recorded := http.Header{}
+	for k, val := range hdr {
+		if headerNameMatches(k, pcbr.RecordRequestHeaders) {
+			recorded[k] = append([]string(nil), val...)
+		}
+	}
+
+	return pcbr.redactHeaderValues(recorded)
+}
+
+// recordedResponseHeader returns the part of a response header that is saved on the cassette, as
+// per RecordResponseHeaders and DropResponseHeaders. The supplied header is not modified.
+func (pcbr *pcb) recordedResponseHeader(hdr http.Header) http.Header {
+	if len(pcbr.RecordResponseHeaders) == 0 && len(pcbr.DropResponseHeaders) == 0 || hdr == nil {
+		return pcbr.redactHeaderValues(hdr)
+	}
+
+	recorded := http.Header{}
+	for k, val := range hdr {
+		if len(pcbr.RecordResponseHeaders) > 0 && !headerNameMatches(k, pcbr.RecordResponseHeaders) {
+			continue
+		}
+		if headerNameMatches(k, pcbr.DropResponseHeaders) {
+			continue
+		}
+		recorded[k] = append([]string(nil), val...)
+	}
+
+	return pcbr.redactHeaderValues(recorded)
+}
+
+// redactedValue replaces the matches of RedactHeaderValueRegex.
+const redactedValue = "REDACTED"
+
+// redactHeaderValues returns a copy of the header with the matches of RedactHeaderValueRegex in
+// its values replaced with redactedValue. The header is returned as is when no value matches.
+func (pcbr *pcb) redactHeaderValues(hdr http.Header) http.Header {
+	if pcbr.RedactHeaderValueRegex == nil || hdr == nil {
+		return hdr
+	}
+
+	var redacted http.Header
+	for k, val := range hdr {
+		for i, v := range val {
+			if !pcbr.RedactHeaderValueRegex.MatchString(v) {
+				continue
+			}
+			if redacted == nil {
+				redacted = cloneHeader(hdr)
+			}
+			redacted[k][i] = pcbr.RedactHeaderValueRegex.ReplaceAllString(v, redactedValue)
+		}
+	}
+
+	if redacted == nil {
+		return hdr
+	}
+
+	return redacted
+}
+
+// headerNameMatches indicates whether the header key matches one of the names, regardless of case.
+// A name that ends with "*" matches the keys that start with the rest of the name.
+func headerNameMatches(key string, names []string) bool {
+	key = http.CanonicalHeaderKey(key)
+	for _, name := range names {
+		if prefix := strings.TrimSuffix(name, "*"); prefix != name {
+			if strings.HasPrefix(strings.ToLower(key), strings.ToLower(prefix)) {
+				return true
+			}
+		} else if key == http.CanonicalHeaderKey(name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// authScheme returns the scheme of the value of an Authorization header (i.e. "Bearer").
+func authScheme(authorization string) string {
+	authorization = strings.TrimSpace(authorization)
+	if idx := strings.IndexAny(authorization, " \t"); idx != -1 {
+		return authorization[:idx]
+	}
+
+	return authorization
+}
+
+// urlResembles compares URLs for equivalence.
+func (pcbr *pcb) urlResembles(url1 *url.URL, url2 *url.URL, ignoreHost bool) bool {
+	if url1 == nil || url2 == nil {
+		return url1 == url2
+	}
+
+	return pcbr.matchedURL(url1, ignoreHost) == pcbr.matchedURL(url2, ignoreHost)
+}
+
+// matchedURL returns the form of the URL that is compared by urlResembles.
+func (pcbr *pcb) matchedURL(u *url.URL, ignoreHost bool) string {
+	matched := *u
+
+	if pcbr.RequestURLFilterFunc != nil {
+		pcbr.RequestURLFilterFunc(&matched)
+	}
+
+	if ignoreHost || pcbr.MatchPathOnly {
+		matched.Scheme, matched.User, matched.Host = "", nil, ""
+	}
+
+	if !pcbr.MatchFragment {
+		matched.Fragment, matched.RawFragment = "", ""
+	}
+
+	if pcbr.IgnorePathVersion {
+		stripPathVersion(&matched)
+	}
+
+	if pcbr.PathTemplate != "" && pathFollowsTemplate(pcbr.matchedPath(&matched), pcbr.PathTemplate) {
+		matched.Path, matched.RawPath = pcbr.PathTemplate, ""
+	}
+
+	return matched.String()
+}
+
+// pathVersionRegexp matches a leading API version segment of a URL path.
+var pathVersionRegexp = regexp.MustCompile(`^/v[0-9]+(/|$)`)
+
+// stripPathVersion removes the leading API version segment (i.e. "/v1") from the path of u.
+func stripPathVersion(u *url.URL) {
+	u.Path = pathVersionRegexp.ReplaceAllString(u.Path, "/")
+	if u.RawPath != "" {
+		u.RawPath = pathVersionRegexp.ReplaceAllString(u.RawPath, "/")
+	}
+}
+
+// matchedPath returns the form of the URL path that path based matching operates on.
+func (pcbr *pcb) matchedPath(u *url.URL) string {
+	if pcbr.MatchRawPath {
+		return u.EscapedPath()
+	}
+
+	return u.Path
+}
+
+// pathFollowsTemplate indicates whether the path matches the template, where template segments
+// in braces (i.e. "{id}") match any non-empty segment.
+func pathFollowsTemplate(path string, template string) bool {
+	pathSegments := strings.Split(path, "/")
+	templateSegments := strings.Split(template, "/")
+	if len(pathSegments) != len(templateSegments) {
+		return false
+	}
+
+	for i, ts := range templateSegments {
+		if strings.HasPrefix(ts, "{") && strings.HasSuffix(ts, "}") {
+			if pathSegments[i] == "" {
+				return false
+			}
+			continue
+		}
+		if ts != pathSegments[i] {
+			return false
+		}
+	}
+
+	return true
 }
 
 // headerResembles compares HTTP headers for equivalence.
@@ -107,7 +1222,86 @@ func (pcbr *pcb) headerResembles(header1 http.Header, header2 http.Header) bool
 
 // bodyResembles compares HTTP bodies for equivalence.
 func (pcbr *pcb) bodyResembles(body1 []byte, body2 []byte) bool {
-	return bytes.Equal(body1, body2)
+	if bytes.Equal(body1, body2) {
+		return true
+	}
+
+	if pcbr.XMLBodyMatch {
+		xml1, err1 := canonicalXML(body1)
+		xml2, err2 := canonicalXML(body2)
+		if err1 == nil && err2 == nil {
+			return bytes.Equal(xml1, xml2)
+		}
+	}
+
+	return false
+}
+
+// canonicalXML returns a canonical form of an XML document, without the whitespace between
+// elements and with the attributes sorted.
+// An error is returned when the data is not an XML document.
+func canonicalXML(data []byte) ([]byte, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+
+	var out bytes.Buffer
+	enc := xml.NewEncoder(&out)
+
+	elements := 0
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			elements++
+			sort.Slice(t.Attr, func(i, j int) bool {
+				if t.Attr[i].Name.Space != t.Attr[j].Name.Space {
+					return t.Attr[i].Name.Space < t.Attr[j].Name.Space
+				}
+				return t.Attr[i].Name.Local < t.Attr[j].Name.Local
+			})
+			tok = t
+		case xml.CharData:
+			if len(bytes.TrimSpace(t)) == 0 {
+				continue
+			}
+		}
+
+		if err := enc.EncodeToken(tok); err != nil {
+			return nil, err
+		}
+	}
+
+	if elements == 0 {
+		return nil, errors.New("govcr: not an XML document")
+	}
+
+	if err := enc.Flush(); err != nil {
+		return nil, err
+	}
+
+	return out.Bytes(), nil
+}
+
+// skipBody indicates whether the body of a response with the supplied header should not be recorded.
+func (pcbr *pcb) skipBody(respHdr http.Header) bool {
+	contentType := strings.ToLower(GetFirstValue(respHdr, "Content-Type"))
+	if contentType == "" {
+		return false
+	}
+
+	for _, ct := range pcbr.SkipBodyContentTypes {
+		if strings.HasPrefix(contentType, strings.ToLower(ct)) {
+			return true
+		}
+	}
+
+	return false
 }
 
 func (pcbr *pcb) filterResponse(resp *http.Response, reqHdr http.Header) *http.Response {
@@ -121,9 +1315,56 @@ func (pcbr *pcb) filterResponse(resp *http.Response, reqHdr http.Header) *http.R
 	resp.Header = *newHeader
 	resp.Body = toReadCloser(*newBody)
 
+	if !pcbr.PreserveContentLength && resp.ContentLength != -1 && len(*newBody) != len(body) {
+		setContentLength(resp, int64(len(*newBody)))
+	}
+
+	return resp
+}
+
+// overrideResponse applies VCRConfig.ReplayResponseFunc to a response played back from the cassette.
+func (pcbr *pcb) overrideResponse(resp *http.Response, req *http.Request) *http.Response {
+	k7Request, err := newRequest(req)
+	if err != nil {
+		pcbr.Logger.Println(err)
+		return resp
+	}
+	k7Response, err := newResponse(resp)
+	if err != nil {
+		pcbr.Logger.Println(err)
+		return resp
+	}
+	original := k7Response
+
+	pcbr.ReplayResponseFunc(k7Request, &k7Response)
+
+	resp.StatusCode = k7Response.StatusCode
+	resp.Status = k7Response.Status
+	if k7Response.StatusCode != original.StatusCode && k7Response.Status == original.Status {
+		resp.Status = fmt.Sprintf("%d %s", k7Response.StatusCode, http.StatusText(k7Response.StatusCode))
+	}
+	resp.Header = k7Response.Header
+	resp.Trailer = k7Response.Trailer
+	resp.Body = toReadCloser(k7Response.Body)
+	resp.ContentLength = k7Response.ContentLength
+	if !pcbr.PreserveContentLength && resp.ContentLength != -1 && len(k7Response.Body) != len(original.Body) {
+		setContentLength(resp, int64(len(k7Response.Body)))
+	}
+
 	return resp
 }
 
+// setContentLength sets the length of the response body, in the Content-Length header too
+// when it is present.
+func setContentLength(resp *http.Response, length int64) {
+	resp.ContentLength = length
+	for k := range resp.Header {
+		if strings.EqualFold(k, "Content-Length") {
+			resp.Header[k] = []string{strconv.FormatInt(length, 10)}
+		}
+	}
+}
+
 // GetFirstValue is a utility function that extracts the first value of a header key.
 // The reason for this function is that some servers require case sensitive headers which
 // prevent the use of http.Header.Get() as it expects header keys to be canonicalized.
@@ -140,13 +1381,97 @@ func GetFirstValue(hdr http.Header, key string) string {
 	return ""
 }
 
+// canonicalHeader returns a copy of the header with all keys in their canonical form.
+// Values of keys that only differ by case are merged, in the sorted order of the keys.
+func canonicalHeader(hdr http.Header) http.Header {
+	if hdr == nil {
+		return nil
+	}
+
+	keys := make([]string, 0, len(hdr))
+	for k := range hdr {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	canonical := make(http.Header, len(hdr))
+	for _, k := range keys {
+		ck := http.CanonicalHeaderKey(k)
+		canonical[ck] = append(canonical[ck], hdr[k]...)
+	}
+
+	return canonical
+}
+
+// cloneHeader returns a copy of the header.
+func cloneHeader(hdr http.Header) http.Header {
+	if hdr == nil {
+		return nil
+	}
+
+	clone := make(http.Header, len(hdr))
+	for k, val := range hdr {
+		clone[k] = append([]string(nil), val...)
+	}
+
+	return clone
+}
+
+// lockedReader is an io.Reader that is safe for concurrent use.
+type lockedReader struct {
+	mu sync.Mutex
+	r  io.Reader
+}
+
+func (lr *lockedReader) Read(p []byte) (int, error) {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+
+	return lr.r.Read(p)
+}
+
+// newDeterministicRand returns a pseudo-random source of data seeded from the supplied name.
+func newDeterministicRand(name string) io.Reader {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+
+	return &lockedReader{r: rand.New(rand.NewSource(int64(h.Sum64())))}
+}
+
+// defaultPCB returns a pcb with the default matching, for use outside of a VCR.
+func defaultPCB() *pcb {
+	return &pcb{
+		ExcludeHeaderFunc: func(key string) bool {
+			return false
+		},
+		RequestFilterFunc: func(header http.Header, body []byte) (*http.Header, *[]byte) {
+			return &header, &body
+		},
+		Logger: log.New(ioutil.Discard, "", log.LstdFlags),
+	}
+}
+
 // NewVCR creates a new VCR and loads a cassette.
 // A RoundTripper can be provided when a custom Transport is needed (for example to provide
 // certificates, etc)
+// NewVCR exits the program when the cassette cannot be loaded: see NewVCRE to handle the error.
 func NewVCR(cassetteName string, vcrConfig *VCRConfig) *VCRControlPanel {
+	vcr, err := NewVCRE(cassetteName, vcrConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return vcr
+}
+
+// NewVCRE creates a new VCR and loads a cassette, as NewVCR does, but returns the error when
+// the cassette cannot be loaded, i.e. ErrCassetteNotFound with VCRConfig.RequireCassetteExists.
+func NewVCRE(cassetteName string, vcrConfig *VCRConfig) (*VCRControlPanel, error) {
 	if vcrConfig == nil {
 		vcrConfig = &VCRConfig{}
 	}
+	callerConfig := vcrConfig
+	vcrConfig = withDefaultConfig(vcrConfig)
 
 	// set up logging
 	logger := log.New(os.Stderr, "", log.LstdFlags)
@@ -165,6 +1490,14 @@ func NewVCR(cassetteName string, vcrConfig *VCRConfig) *VCRControlPanel {
 		vcrConfig.Client.Transport = http.DefaultTransport
 	}
 
+	// use a deterministic source of random data if none provided
+	if vcrConfig.Rand == nil {
+		vcrConfig.Rand = newDeterministicRand(cassetteName)
+	}
+	// the filters read Rand from the configuration they capture, which is not the merge of
+	// withDefaultConfig
+	callerConfig.Rand = vcrConfig.Rand
+
 	// use a default set of FilterFunc's
 	if vcrConfig.ExcludeHeaderFunc == nil {
 		vcrConfig.ExcludeHeaderFunc = func(key string) bool {
@@ -178,36 +1511,148 @@ func NewVCR(cassetteName string, vcrConfig *VCRConfig) *VCRControlPanel {
 		}
 	}
 
+	if !vcrConfig.FrozenTime.IsZero() && len(vcrConfig.FreezeTimeHeaders) == 0 {
+		vcrConfig.FreezeTimeHeaders = []string{"Date"}
+	}
+
+	if vcrConfig.Now == nil {
+		vcrConfig.Now = time.Now
+	}
+
 	if vcrConfig.ResponseFilterFunc == nil {
 		vcrConfig.ResponseFilterFunc = func(respHdr http.Header, body []byte, reqHdr http.Header) (*http.Header, *[]byte) {
 			return &respHdr, &body
 		}
 	}
 
+	// create PCB
+	pcbr := &pcb{
+		// TODO: create appropriate test!
+		DisableRecording:         vcrConfig.DisableRecording,
+		Transport:                withDialRewrites(vcrConfig.Client.Transport, vcrConfig.DialRewrites, logger),
+		ExcludeHeaderFunc:        vcrConfig.ExcludeHeaderFunc,
+		RequestFilterFunc:        vcrConfig.RequestFilterFunc,
+		ResponseFilterFunc:       vcrConfig.ResponseFilterFunc,
+		RecordResponseFilterFunc: vcrConfig.RecordResponseFilterFunc,
+		Logger:                   logger,
+		CassettePath:             vcrConfig.CassettePath,
+		PreserveHeaderCase:       vcrConfig.PreserveHeaderCase,
+		HashRequestBodies:        vcrConfig.HashRequestBodies,
+		SkipBodyContentTypes:     vcrConfig.SkipBodyContentTypes,
+		AuthSchemeMatch:          vcrConfig.AuthSchemeMatch,
+		MaxRecordedTracks:        vcrConfig.MaxRecordedTracks,
+		ShouldMatchBody:          vcrConfig.ShouldMatchBody,
+		ExhaustedTracks:          vcrConfig.ExhaustedTracks,
+		PathTemplate:             vcrConfig.PathTemplate,
+		RequestURLFilterFunc:     vcrConfig.RequestURLFilterFunc,
+		RecordRequestHeaders:     vcrConfig.RecordRequestHeaders,
+		CassetteRouter:           vcrConfig.CassetteRouter,
+		MatchRawPath:             vcrConfig.MatchRawPath,
+		MatchHost:                vcrConfig.MatchHost,
+		HeaderOrderInsensitive:   vcrConfig.HeaderOrderInsensitive,
+		EnforceOrder:             vcrConfig.EnforceOrder,
+		FrozenTime:               vcrConfig.FrozenTime,
+		FreezeTimeHeaders:        vcrConfig.FreezeTimeHeaders,
+		RecordIf:                 vcrConfig.RecordIf,
+		ReplayLatency:            vcrConfig.ReplayLatency,
+		ReplayJitter:             vcrConfig.ReplayJitter,
+		Rand:                     vcrConfig.Rand,
+		XMLBodyMatch:             vcrConfig.XMLBodyMatch,
+		StrictHEAD:               vcrConfig.StrictHEAD,
+		MatchTrace:               vcrConfig.MatchTrace,
+		RecordRawRequest:         vcrConfig.RecordRawRequest,
+		MaxLiveCalls:             vcrConfig.MaxLiveCalls,
+		DeduplicateTracks:        vcrConfig.DeduplicateTracks,
+		JSONPathMatch:            vcrConfig.JSONPathMatch,
+		MatchFragment:            vcrConfig.MatchFragment,
+		IgnoreRangeRequests:      vcrConfig.IgnoreRangeRequests || vcrConfig.SynthesizeRangeResponses,
+		SynthesizeRangeResponses: vcrConfig.SynthesizeRangeResponses,
+		PreserveContentLength:    vcrConfig.PreserveContentLength,
+		Observer:                 vcrConfig.Observer,
+		AutoRespondPreflight:     vcrConfig.AutoRespondPreflight,
+		PreflightHeaders:         vcrConfig.PreflightHeaders,
+		LatencyFunc:              vcrConfig.LatencyFunc,
+		IgnorePathVersion:        vcrConfig.IgnorePathVersion,
+		ReplayResponseFunc:       vcrConfig.ReplayResponseFunc,
+		RecordResponseHeaders:    vcrConfig.RecordResponseHeaders,
+		DropResponseHeaders:      vcrConfig.DropResponseHeaders,
+		MatchFingerprintOnly:     vcrConfig.MatchFingerprintOnly,
+		FingerprintSalt:          vcrConfig.FingerprintSalt,
+		BodyCanonicalizers:       vcrConfig.BodyCanonicalizers,
+		Now:                      vcrConfig.Now,
+		RateLimit:                vcrConfig.RateLimit,
+		MatchPathOnly:            vcrConfig.MatchPathOnly,
+		GoldenMode:               vcrConfig.GoldenMode,
+		RedactHeaderValueRegex:   vcrConfig.RedactHeaderValueRegex,
+		TrimBodyWhitespace:       vcrConfig.TrimBodyWhitespace,
+		CollapseBodyWhitespace:   vcrConfig.CollapseBodyWhitespace,
+		BestMatch:                vcrConfig.BestMatch,
+		BestMatchThreshold:       vcrConfig.BestMatchThreshold,
+		BestMatchWeights:         vcrConfig.BestMatchWeights,
+	}
+
+	openCassette := func(name string) (*Cassette, error) {
+		key := name
+		if vcrConfig.KeyFunc != nil {
+			key = vcrConfig.KeyFunc(name)
+		}
+
+		format := vcrConfig.Format
+		if vcrConfig.OneFilePerTrack {
+			format = formatTrackFiles
+		}
+
+		if vcrConfig.OnOpen != nil {
+			vcrConfig.OnOpen(name)
+		}
+
+		cassette, err := loadLockedCassette(key, vcrConfig, format)
+		if err == nil {
+			err = pcbr.removeDuplicateTracks(cassette, vcrConfig.OnDuplicateTrack)
+		}
+		if err != nil {
+			if vcrConfig.OnClose != nil {
+				vcrConfig.OnClose(name)
+			}
+			return nil, err
+		}
+		cassette.Name, cassette.key = name, key
+
+		cassette.tempDir = vcrConfig.TempDir
+		cassette.fileLock, cassette.fileLockTimeout = vcrConfig.FileLock, vcrConfig.FileLockTimeout
+
+		return cassette, nil
+	}
+
 	// load cassette
-	cassette, err := loadCassette(cassetteName, vcrConfig.CassettePath)
+	cassette, err := openCassette(cassetteName)
 	if err != nil {
-		logger.Fatal(err)
+		return nil, err
 	}
 
-	// create PCB
-	pcbr := &pcb{
-		// TODO: create appropriate test!
-		DisableRecording:   vcrConfig.DisableRecording,
-		Transport:          vcrConfig.Client.Transport,
-		ExcludeHeaderFunc:  vcrConfig.ExcludeHeaderFunc,
-		RequestFilterFunc:  vcrConfig.RequestFilterFunc,
-		ResponseFilterFunc: vcrConfig.ResponseFilterFunc,
-		Logger:             logger,
-		CassettePath:       vcrConfig.CassettePath,
+	if vcrConfig.RecoverFilterPanics {
+		pcbr.RequestFilterFunc = recoverRequestFilter(pcbr.RequestFilterFunc, logger)
+		pcbr.ResponseFilterFunc = recoverResponseFilter(pcbr.ResponseFilterFunc, logger)
+		if pcbr.RecordResponseFilterFunc != nil {
+			pcbr.RecordResponseFilterFunc = recoverResponseFilter(pcbr.RecordResponseFilterFunc, logger)
+		}
 	}
 
 	// create VCR's HTTP client
+	vcrT := &vcrTransport{
+		PCB:          pcbr,
+		Cassette:     cassette,
+		openCassette: openCassette,
+		onClose:      vcrConfig.OnClose,
+	}
+	if cassette.RecordedAt != nil {
+		vcrT.now = *cassette.RecordedAt
+	} else {
+		vcrT.now = vcrConfig.Now()
+	}
+	cassette.transport = vcrT
 	vcrClient := &http.Client{
-		Transport: &vcrTransport{
-			PCB:      pcbr,
-			Cassette: cassette,
-		},
+		Transport: vcrT,
 	}
 
 	// copy the attributes of the original http.Client
@@ -218,7 +1663,39 @@ func NewVCR(cassetteName string, vcrConfig *VCRConfig) *VCRControlPanel {
 	// return
 	return &VCRControlPanel{
 		Client: vcrClient,
+	}, nil
+}
+
+// tempCassetteName is the name of the cassette created by NewTempVCR.
+const tempCassetteName = "govcr-temp"
+
+// NewTempVCR creates a new VCR with a cassette held in a new temporary directory, rather than
+// in VCRConfig.CassettePath. This suits one-off tests that should not leave fixtures behind.
+// The returned function removes the directory and its cassette. It can be called more than
+// once, i.e. with t.Cleanup.
+func NewTempVCR(vcrConfig *VCRConfig) (*VCRControlPanel, func(), error) {
+	dir, err := ioutil.TempDir("", "govcr-")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cfg := VCRConfig{}
+	if vcrConfig != nil {
+		cfg = *vcrConfig
+	}
+	cfg.CassettePath = dir
+
+	cleanup := func() {
+		os.RemoveAll(dir)
+	}
+
+	vcr, err := NewVCRE(tempCassetteName, &cfg)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
 	}
+
+	return vcr, cleanup, nil
 }
 
 // ExcludeHeaderFunc is a hook function that is used to filter the Header.
@@ -244,6 +1721,7 @@ type ExcludeHeaderFunc func(key string) bool
 // For instance, if your application sends requests with a timestamp held in a part of
 // the header / body, you likely want to remove it or force a static timestamp via
 // RequestFilterFunc to ensure that the request body matches those saved on the cassette's track.
+// RequestFreezeJSONTime does the latter for JSON bodies.
 //
 // It is important to note that this differs from ExcludeHeaderFunc in that the former does not
 // modify the header (it only returns a bool) whereas this function can be used to modify the header.
@@ -272,57 +1750,430 @@ type RequestFilterFunc func(http.Header, []byte) (*http.Header, *[]byte)
 //  - value 2 - Response's amended body
 type ResponseFilterFunc func(http.Header, []byte, http.Header) (*http.Header, *[]byte)
 
+// URLFilterFunc is a hook function that is used to filter the URL of the Request when matching,
+// since RequestFilterFunc is not supplied the URL.
+//
+// Parameters:
+//  - parameter 1 - Copy of the URL of the Request, to amend in place
+type URLFilterFunc func(*url.URL)
+
+// recoverRequestFilter wraps a RequestFilterFunc so that the original header / body are
+// returned should it panic.
+func recoverRequestFilter(filter RequestFilterFunc, logger *log.Logger) RequestFilterFunc {
+	return func(header http.Header, body []byte) (newHeader *http.Header, newBody *[]byte) {
+		defer func() {
+			if r := recover(); r != nil {
+				logger.Printf("ERROR - RequestFilterFunc panicked so leaving the request untouched: %v\n", r)
+				newHeader, newBody = &header, &body
+			}
+		}()
+
+		return filter(header, body)
+	}
+}
+
+// recoverResponseFilter wraps a ResponseFilterFunc so that the original header / body are
+// returned should it panic.
+func recoverResponseFilter(filter ResponseFilterFunc, logger *log.Logger) ResponseFilterFunc {
+	return func(respHdr http.Header, body []byte, reqHdr http.Header) (newHeader *http.Header, newBody *[]byte) {
+		defer func() {
+			if r := recover(); r != nil {
+				logger.Printf("ERROR - ResponseFilterFunc panicked so leaving the response untouched: %v\n", r)
+				newHeader, newBody = &respHdr, &body
+			}
+		}()
+
+		return filter(respHdr, body, reqHdr)
+	}
+}
+
 // vcrTransport is the heart of VCR. It provides
 // an http.RoundTripper that wraps over the default
 // one provided by Go's http package or a custom one
 // if specified when calling NewVCR.
 type vcrTransport struct {
 	PCB      *pcb
-	Cassette *cassette
+	Cassette *Cassette
+
+	// openCassette loads the cassettes selected by VCRConfig.CassetteRouter.
+	openCassette func(name string) (*Cassette, error)
+
+	// onClose is VCRConfig.OnClose.
+	onClose func(name string)
+
+	// now is the time returned by VCRControlPanel.Now.
+	now time.Time
+
+	// nowRead is set to 1 (atomically) once VCRControlPanel.Now has been called, after which now
+	// is saved on the cassette as Cassette.RecordedAt.
+	nowRead int32
+
+	// mu guards the fields below.
+	mu sync.Mutex
+
+	// routed holds the cassettes loaded by cassetteFor, by name.
+	routed map[string]*Cassette
+
+	// misses holds a description of the requests for which no track matched.
+	misses []string
+
+	// maxRecordedTracksHit indicates that the limit set by VCRConfig.MaxRecordedTracks was reached.
+	maxRecordedTracksHit bool
+
+	// outOfOrder indicates that a track was played back before a track recorded ahead of it.
+	outOfOrder bool
+
+	// liveCalls is the number of requests executed live.
+	liveCalls int
+
+	// liveURLs holds a description of the requests executed live.
+	liveURLs []string
+
+	// rateLimitCalls is the number of matching requests counted by VCRConfig.RateLimit.
+	rateLimitCalls int
+
+	// closed indicates that VCRControlPanel.Close has been called.
+	closed bool
 }
 
 // RoundTrip is an implementation of http.RoundTripper.
 func (t *vcrTransport) RoundTrip(req *http.Request) (*http.Response, error) {
-	var (
-		// Note: by convention resp should be nil if an error occurs with HTTP
-		resp *http.Response
+	if t.PCB.Observer == nil {
+		resp, _, err := t.roundTrip(req)
+		return resp, err
+	}
+
+	return t.observedRoundTrip(req)
+}
 
+// roundTrip plays back or executes the request. replayed indicates whether the response
+// was played back from the cassette.
+func (t *vcrTransport) roundTrip(req *http.Request) (resp *http.Response, replayed bool, err error) {
+	// Note: by convention resp should be nil if an error occurs with HTTP
+	var (
 		requestMatched bool
 		copiedReq      *http.Request
 	)
 
+	t.mu.Lock()
+	closed := t.closed
+	t.mu.Unlock()
+	if closed {
+		err = fmt.Errorf("%w: %s %s", ErrVCRClosed, req.Method, req.URL.String())
+		t.PCB.Logger.Println(err)
+		return nil, false, err
+	}
+
 	// copy the request before the body is closed by the HTTP server.
-	copiedReq, err := copyRequest(req)
+	copiedReq, err = copyRequest(req)
 	if err != nil {
 		t.PCB.Logger.Println(err)
-		return nil, err
+		return nil, false, err
+	}
+
+	if resp := t.PCB.preflightResponse(req); resp != nil {
+		t.PCB.Logger.Printf("INFO - Cassette '%s' - Responding to preflight request for %s\n", t.Cassette.Name, req.URL.String())
+		return resp, false, nil
+	}
+
+	// the live request, which differs from req when the range headers are dropped or when
+	// GetBody is supplied
+	liveReq := req
+	if t.PCB.IgnoreRangeRequests && req.Header.Get("Range") != "" {
+		copiedReq.Header = withoutRangeHeaders(copiedReq.Header)
+		liveReq = req.Clone(req.Context())
+		liveReq.Header = cloneHeader(copiedReq.Header)
+	}
+	if req.GetBody == nil && copiedReq.GetBody != nil {
+		// the caller's request is not modified
+		if liveReq == req {
+			liveReq = req.WithContext(req.Context())
+		}
+		liveReq.GetBody = copiedReq.GetBody
 	}
 
 	// attempt to use a track from the cassette that matches
 	// the request if one exists.
-	if trackNumber := t.PCB.seekTrack(t.Cassette, copiedReq); trackNumber != trackNotFound {
-		// only the played back response is filtered. Never the live response!
-		resp = t.PCB.filterResponse(t.Cassette.replayResponse(trackNumber, copiedReq), copiedReq.Header)
+	t.mu.Lock()
+	cassette, err := t.cassetteFor(copiedReq)
+	trackNumber, replayedTrack := trackNotFound, trackNotFound
+	if err == nil {
+		trackNumber, err = t.matchTrack(cassette, copiedReq, false, true)
+	}
+	if trackNumber != trackNotFound {
+		err = t.checkReplayOrder(cassette, trackNumber, copiedReq)
+	}
+	if trackNumber != trackNotFound && err == nil {
+		if resp = t.rateLimitedResponse(copiedReq); resp == nil {
+			resp = t.replayTrack(cassette, trackNumber, copiedReq)
+			replayedTrack = trackNumber
+		}
 		requestMatched = true
 	}
+	t.mu.Unlock()
+
+	if err != nil {
+		t.PCB.Logger.Println(err)
+		return nil, false, err
+	}
+
+	if replayedTrack != trackNotFound {
+		resp = t.filterReplayedResponse(cassette, resp, copiedReq)
+	}
+
+	if t.PCB.GoldenMode && replayedTrack != trackNotFound {
+		if err := t.verifyGolden(cassette, replayedTrack, copiedReq, liveReq); err != nil {
+			t.PCB.Logger.Println(err)
+			return nil, true, err
+		}
+	}
+
+	if requestMatched {
+		if err := sleepContext(req.Context(), t.PCB.replayDelay(copiedReq)); err != nil {
+			return nil, false, err
+		}
+	}
 
 	if !requestMatched {
+		t.mu.Lock()
+		t.misses = append(t.misses, req.Method+" "+req.URL.String())
+		if t.PCB.MatchTrace {
+			t.PCB.traceMiss(cassette, copiedReq)
+		}
+		err = t.countLiveCall(req)
+		t.mu.Unlock()
+
+		if err != nil {
+			t.PCB.Logger.Println(err)
+			return nil, false, err
+		}
+
 		// no recorded track was found so execute the request live
-		t.PCB.Logger.Printf("INFO - Cassette '%s' - Executing request to live server for %s %s\n", t.Cassette.Name, req.Method, req.URL.String())
+		t.PCB.Logger.Printf("INFO - Cassette '%s' - Executing request to live server for %s %s\n", cassette.Name, req.Method, req.URL.String())
 
-		resp, err = t.PCB.Transport.RoundTrip(req)
+		trace := &liveTrace{}
+		resp, err = t.PCB.Transport.RoundTrip(trace.withClientTrace(liveReq))
 
 		if !t.PCB.DisableRecording {
 			// the VCR is not in read-only mode so
 			// record the HTTP traffic into a new track on the cassette
-			t.PCB.Logger.Printf("INFO - Cassette '%s' - Recording new track for %s %s\n", t.Cassette.Name, req.Method, req.URL.String())
-			if err := recordNewTrackToCassette(t.Cassette, copiedReq, resp, err); err != nil {
-				t.PCB.Logger.Println(err)
+			if recordErr := t.recordTrack(cassette, copiedReq, resp, err, trace); recordErr != nil {
+				if resp != nil {
+					resp.Body.Close()
+				}
+				return nil, false, recordErr
+			}
+		}
+	}
+
+	if t.PCB.SynthesizeRangeResponses && err == nil {
+		resp = synthesizeRangeResponse(resp, req.Header)
+	}
+
+	return resp, requestMatched, err
+}
+
+// recordTrack records the HTTP traffic into a new track on the cassette. It returns the error of
+// saving the cassette.
+func (t *vcrTransport) recordTrack(cassette *Cassette, req *http.Request, resp *http.Response, httpErr error, trace *liveTrace) error {
+	// the lock of the cassette file is acquired before t.mu, so as not to block the VCR while
+	// another process holds it
+	if cassette.fileLock {
+		unlock, err := lockFile(cassette.lockFilename(), cassette.fileLockTimeout)
+		if err != nil {
+			t.PCB.Logger.Println(err)
+			return err
+		}
+		defer unlock()
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	cassette.fileLocked = cassette.fileLock
+	defer func() { cassette.fileLocked = false }()
+
+	if t.PCB.MaxRecordedTracks > 0 && cassette.tracksRecorded() >= t.PCB.MaxRecordedTracks {
+		if !t.maxRecordedTracksHit {
+			t.PCB.Logger.Printf("WARNING - Cassette '%s' - Maximum number of recorded tracks (%d) reached, new tracks will not be recorded\n", cassette.Name, t.PCB.MaxRecordedTracks)
+			t.maxRecordedTracksHit = true
+		}
+		return nil
+	}
+
+	if t.PCB.DeduplicateTracks && t.PCB.seekExhaustedTrack(cassette, req, false) != trackNotFound {
+		t.PCB.Logger.Printf("INFO - Cassette '%s' - Not recording a duplicate track for %s %s\n", cassette.Name, req.Method, req.URL.String())
+		return nil
+	}
+
+	t.PCB.Logger.Printf("INFO - Cassette '%s' - Recording new track for %s %s\n", cassette.Name, req.Method, req.URL.String())
+	if err := t.PCB.recordNewTrackToCassette(cassette, req, resp, httpErr, trace); err != nil {
+		t.PCB.Logger.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+// matchTrack looks for the track to play back for the request, applying the
+// VCRConfig.ExhaustedTracks policy and VCRConfig.BestMatch. replay indicates whether the track is
+// to be played back: the tracks that do not match exactly are then logged and counted in the
+// stats. The caller must hold t.mu.
+func (t *vcrTransport) matchTrack(cassette *Cassette, req *http.Request, ignoreHost, replay bool) (int, error) {
+	return t.matchTrackWith(cassette, req, ignoreHost, replay, t.PCB.ExhaustedTracks)
+}
+
+// matchTrackWith is matchTrack with the exhausted policy in place of VCRConfig.ExhaustedTracks.
+// The caller must hold t.mu.
+func (t *vcrTransport) matchTrackWith(cassette *Cassette, req *http.Request, ignoreHost, replay bool, exhausted ExhaustedTracksPolicy) (int, error) {
+	trackNumber := t.PCB.seekTrackByURL(cassette, req, ignoreHost)
+	if trackNumber != trackNotFound {
+		return trackNumber, nil
+	}
+
+	if exhausted != ExhaustedTracksLive {
+		exhaustedTrackNumber := t.PCB.seekExhaustedTrack(cassette, req, ignoreHost)
+		switch {
+		case exhaustedTrackNumber == trackNotFound:
+		case exhausted == ExhaustedTracksRepeatLast:
+			if !replay {
+				return exhaustedTrackNumber, nil
 			}
+			t.PCB.Logger.Printf("INFO - Cassette '%s' - Repeating the last matching track for %s %s\n", cassette.Name, req.Method, req.URL.String())
+			return exhaustedTrackNumber, nil
+		default:
+			return trackNotFound, fmt.Errorf("%w: %s %s", ErrTracksExhausted, req.Method, req.URL.String())
+		}
+	}
+
+	if !t.PCB.BestMatch {
+		return trackNotFound, nil
+	}
+
+	trackNumber, score := t.PCB.bestMatchTrack(cassette, req, ignoreHost)
+	if trackNumber != trackNotFound && replay {
+		t.PCB.Logger.Printf("INFO - Cassette '%s' - Playing back the best matching track #%d (score %.2f) for %s %s\n", cassette.Name, trackNumber, score, req.Method, req.URL.String())
+		if cassette.stats.TracksBestMatched == 0 || score < cassette.stats.LowestBestMatchScore {
+			cassette.stats.LowestBestMatchScore = score
+		}
+		cassette.stats.TracksBestMatched++
+	}
+
+	return trackNumber, nil
+}
+
+// countLiveCall counts a live call, applying VCRConfig.MaxLiveCalls.
+// The caller must hold t.mu.
+func (t *vcrTransport) countLiveCall(req *http.Request) error {
+	if t.PCB.MaxLiveCalls > 0 && t.liveCalls >= t.PCB.MaxLiveCalls {
+		return fmt.Errorf("%w (%d): %s %s", ErrMaxLiveCalls, t.PCB.MaxLiveCalls, req.Method, req.URL.String())
+	}
+
+	t.liveCalls++
+	t.liveURLs = append(t.liveURLs, req.Method+" "+req.URL.String())
+
+	return nil
+}
+
+// checkReplayOrder records whether playing back the track respects the order of the recording
+// and applies VCRConfig.EnforceOrder. The caller must hold t.mu.
+func (t *vcrTransport) checkReplayOrder(cassette *Cassette, trackNumber int, req *http.Request) error {
+	if trackNumber+1 < cassette.lastReplayed[sequenceKey(req.Context())] {
+		t.outOfOrder = true
+		if t.PCB.EnforceOrder {
+			return fmt.Errorf("%w: cassette '%s' - track #%d for %s %s", ErrOutOfOrder, cassette.Name, trackNumber, req.Method, req.URL.String())
 		}
 	}
 
-	return resp, err
+	return nil
+}
+
+// replayTrack plays back the track of the cassette for the supplied request. The caller must hold
+// t.mu, and then filter the response with filterReplayedResponse.
+func (t *vcrTransport) replayTrack(cassette *Cassette, trackNumber int, req *http.Request) *http.Response {
+	if remoteAddr := cassette.Tracks[trackNumber].RemoteAddr; remoteAddr != "" {
+		t.PCB.Logger.Printf("INFO - Cassette '%s' - Replaying track originally served by %s\n", cassette.Name, remoteAddr)
+	}
+
+	resp := cassette.replayResponse(trackNumber, req)
+	if cassette.lastReplayed == nil {
+		cassette.lastReplayed = map[string]int{}
+	}
+	cassette.lastReplayed[sequenceKey(req.Context())] = trackNumber + 1
+
+	return resp
+}
+
+// filterReplayedResponse applies the filters to the response played back by replayTrack. It is
+// called without t.mu, so that the user's filters and ReplayResponseFunc can use the VCR.
+func (t *vcrTransport) filterReplayedResponse(cassette *Cassette, resp *http.Response, req *http.Request) *http.Response {
+	resp.Header = cassette.Filters.apply(resp.Header)
+	if !t.PCB.PreserveHeaderCase {
+		resp.Header = canonicalHeader(resp.Header)
+	}
+
+	// only the played back response is filtered. Never the live response!
+	resp = t.PCB.filterResponse(resp, req.Header)
+	if t.PCB.ReplayResponseFunc != nil {
+		resp = t.PCB.overrideResponse(resp, req)
+	}
+
+	if t.PCB.StrictHEAD && req.Method == http.MethodHead {
+		resp.Body = http.NoBody
+	}
+
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		resp.Body = switchedProtocolBody{resp.Body}
+	}
+
+	return resp
+}
+
+// cassetteFor returns the cassette that the request is recorded on and replayed from, as
+// selected by VCRConfig.CassetteRouter. The caller must hold t.mu.
+func (t *vcrTransport) cassetteFor(req *http.Request) (*Cassette, error) {
+	if t.PCB.CassetteRouter == nil {
+		return t.Cassette, nil
+	}
+
+	name := t.PCB.CassetteRouter(req)
+	if name == "" || name == t.Cassette.Name {
+		return t.Cassette, nil
+	}
+
+	if cassette, ok := t.routed[name]; ok {
+		return cassette, nil
+	}
+
+	cassette, err := t.openCassette(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.routed == nil {
+		t.routed = map[string]*Cassette{}
+	}
+	cassette.transport = t
+	t.routed[name] = cassette
+
+	return cassette, nil
+}
+
+// cassettes returns the cassette supplied to NewVCR followed by the cassettes selected by
+// VCRConfig.CassetteRouter, sorted by name. The caller must hold t.mu.
+func (t *vcrTransport) cassettes() []*Cassette {
+	names := make([]string, 0, len(t.routed))
+	for name := range t.routed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	cassettes := []*Cassette{t.Cassette}
+	for _, name := range names {
+		cassettes = append(cassettes, t.routed[name])
+	}
+
+	return cassettes
 }
 
 // copyRequest makes a copy an HTTP request.
@@ -347,6 +2198,14 @@ func copyRequest(req *http.Request) (*http.Request, error) {
 	req.Body = toReadCloser(bodyCopy)
 	copiedReq.Body = toReadCloser(bodyCopy)
 
+	// let the live transport obtain a fresh Body should it need to retry the request,
+	// since the original stream may not be readable twice
+	if req.GetBody == nil && bodyCopy != nil {
+		copiedReq.GetBody = func() (io.ReadCloser, error) {
+			return toReadCloser(bodyCopy), nil
+		}
+	}
+
 	return copiedReq, nil
 }
 
@@ -379,6 +2238,73 @@ func copyRequestWithoutBody(req *http.Request) *http.Request {
 	return &copiedReq
 }
 
+// replayDelay returns the time to wait for before returning a response played back from the
+// cassette, as per LatencyFunc, or ReplayLatency and ReplayJitter.
+func (pcbr *pcb) replayDelay(req *http.Request) time.Duration {
+	delay := pcbr.ReplayLatency
+
+	if pcbr.LatencyFunc != nil {
+		k7Request, err := newRequest(req)
+		if err != nil {
+			pcbr.Logger.Println(err)
+		}
+		delay = pcbr.LatencyFunc(k7Request)
+	} else if pcbr.ReplayJitter > 0 {
+		var buf [8]byte
+		if _, err := io.ReadFull(pcbr.Rand, buf[:]); err != nil {
+			pcbr.Logger.Printf("WARNING - Unable to draw the replay jitter: %s\n", err)
+		}
+		n := binary.BigEndian.Uint64(buf[:]) % uint64(2*pcbr.ReplayJitter+1)
+		delay += time.Duration(n) - pcbr.ReplayJitter
+	}
+
+	if delay < 0 {
+		return 0
+	}
+
+	return delay
+}
+
+// NormalLatency returns a VCRConfig.LatencyFunc that draws latencies from a normal distribution
+// of the supplied mean and standard deviation. Negative latencies are treated as zero.
+// The latencies are drawn from source, which should be safe for concurrent use, or from a
+// deterministic source when source is nil, so that they are reproducible.
+func NormalLatency(mean, stddev time.Duration, source io.Reader) func(req Request) time.Duration {
+	if source == nil {
+		source = newDeterministicRand("NormalLatency")
+	}
+
+	uniform := func() float64 {
+		var buf [8]byte
+		io.ReadFull(source, buf[:])
+		// a float64 in (0, 1]
+		return float64(binary.BigEndian.Uint64(buf[:])>>11+1) / (1 << 53)
+	}
+
+	return func(req Request) time.Duration {
+		// Box-Muller transform
+		z := math.Sqrt(-2*math.Log(uniform())) * math.Cos(2*math.Pi*uniform())
+		return mean + time.Duration(z*float64(stddev))
+	}
+}
+
+// sleepContext waits for d or until ctx is done, in which case the error of ctx is returned.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // readRequestBody reads the Body data stream and restores its states.
 // It ensures the stream is restored to its original state and can be read from again.
 func readRequestBody(req *http.Request) ([]byte, error) {
@@ -427,6 +2353,18 @@ func readResponseBody(resp *http.Response) ([]byte, error) {
 	return bodyData, nil
 }
 
+// switchedProtocolBody is the body of a replayed 101 Switching Protocols response. As the body of
+// a live one, it is an io.ReadWriteCloser. Writes are discarded since the frames of the new
+// protocol are not recorded.
+type switchedProtocolBody struct {
+	io.ReadCloser
+}
+
+// Write implements io.Writer.
+func (switchedProtocolBody) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
 func toReadCloser(body []byte) io.ReadCloser {
 	return ioutil.NopCloser(bytes.NewReader(body))
 }
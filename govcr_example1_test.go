@@ -24,7 +24,8 @@ func runTestEx1() {
 	resp.Body.Close()
 	fmt.Printf("%v ", strings.Contains(string(body), "domain in examples without prior coordination or asking for permission."))
 
-	fmt.Printf("%+v\n", vcr.Stats())
+	stats := vcr.Stats()
+	fmt.Printf("{TracksLoaded:%d TracksRecorded:%d TracksPlayed:%d}\n", stats.TracksLoaded, stats.TracksRecorded, stats.TracksPlayed)
 }
 
 // Example_simpleVCR is an example use of govcr.
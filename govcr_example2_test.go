@@ -60,7 +60,8 @@ func runTestEx2(app *myApp) {
 		fmt.Printf("%v - ", strings.Contains(string(body), td.body))
 	}
 
-	fmt.Printf("%+v\n", vcr.Stats())
+	stats := vcr.Stats()
+	fmt.Printf("{TracksLoaded:%d TracksRecorded:%d TracksPlayed:%d}\n", stats.TracksLoaded, stats.TracksRecorded, stats.TracksPlayed)
 }
 
 // Example2 is an example use of govcr.
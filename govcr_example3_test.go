@@ -50,7 +50,8 @@ func runTestEx4() {
 		fmt.Printf("%v ", strings.Contains(string(body), td.body))
 	}
 
-	fmt.Printf("%+v\n", vcr.Stats())
+	stats := vcr.Stats()
+	fmt.Printf("{TracksLoaded:%d TracksRecorded:%d TracksPlayed:%d}\n", stats.TracksLoaded, stats.TracksRecorded, stats.TracksPlayed)
 }
 
 // Example_simpleVCR is an example use of govcr.
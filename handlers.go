@@ -0,0 +1,193 @@
+package govcr
+
+import (
+	"io"
+	"net/http"
+)
+
+// hopByHopHeaders are the headers that are meaningful only for a single transport-level connection
+// and must not be forwarded by proxies.
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// ProxyHandler returns an http.Handler that acts as a recording HTTP proxy.
+// Requests received by the handler are forwarded to their target through the VCR (and hence
+// recorded or played back) and the response is returned to the caller.
+// This permits recording traffic from any HTTP client that can be configured to use a proxy
+// (a browser, curl, etc). The resulting cassette can be replayed by the VCR's Client.
+//
+// Note: the CONNECT method is not supported, which means https traffic cannot be proxied.
+func (vcr *VCRControlPanel) ProxyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodConnect {
+			http.Error(w, "govcr: CONNECT is not supported", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if !r.URL.IsAbs() {
+			http.Error(w, "govcr: proxy requests must use an absolute URL", http.StatusBadRequest)
+			return
+		}
+
+		outReq := r.Clone(r.Context())
+		outReq.RequestURI = ""
+		for _, h := range hopByHopHeaders {
+			outReq.Header.Del(h)
+		}
+
+		// let the VCR's transport deal with compression, as it does for its own Client,
+		// so that the recorded body is not compressed
+		outReq.Header.Del("Accept-Encoding")
+
+		resp, err := vcr.Client.Transport.RoundTrip(outReq)
+		if err != nil {
+			http.Error(w, "govcr: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		writeResponse(w, resp)
+	})
+}
+
+// writeResponse copies an HTTP response to an http.ResponseWriter.
+func writeResponse(w http.ResponseWriter, resp *http.Response) {
+	for k, val := range resp.Header {
+		for _, v := range val {
+			w.Header().Add(k, v)
+		}
+	}
+	for _, h := range hopByHopHeaders {
+		w.Header().Del(h)
+	}
+
+	w.WriteHeader(resp.StatusCode)
+
+	if resp.Body != nil {
+		io.Copy(w, resp.Body)
+		resp.Body.Close()
+	}
+}
+
+// ServerHandler returns an http.Handler that serves the tracks of the cassette.
+// Incoming requests are matched against the cassette in the same way as requests made with
+// the VCR's Client, except that the scheme and host of the URL are ignored since they are
+// those of the server. When no track matches, the handler responds with 404 Not Found.
+// The handler never makes live calls nor records new tracks: once the tracks that match a request
+// have all been played back, the last one is repeated, as with ExhaustedTracksRepeatLast, unless
+// VCRConfig.ExhaustedTracks is ExhaustedTracksError.
+// This turns a cassette into a mock server that non-Go components can call.
+func (vcr *VCRControlPanel) ServerHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vcrT := vcr.Client.Transport.(*vcrTransport)
+
+		if resp := vcrT.PCB.preflightResponse(r); resp != nil {
+			writeResponse(w, resp)
+			return
+		}
+
+		copiedReq, err := copyRequest(r)
+		if err != nil {
+			vcrT.PCB.Logger.Println(err)
+			http.Error(w, "govcr: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// remove the headers that are the concern of the client's transport,
+		// as they are not part of the recorded request
+		copiedReq.Header = cloneHeader(copiedReq.Header)
+		for _, h := range hopByHopHeaders {
+			copiedReq.Header.Del(h)
+		}
+		copiedReq.Header.Del("Accept-Encoding")
+
+		vcrT.mu.Lock()
+		var (
+			resp     *http.Response
+			replayed *Cassette
+		)
+		if cassette, err := vcrT.cassetteFor(copiedReq); err == nil {
+			exhausted := vcrT.PCB.ExhaustedTracks
+			if exhausted == ExhaustedTracksLive {
+				// there is no live server to call: answer repeated requests as recorded
+				exhausted = ExhaustedTracksRepeatLast
+			}
+			trackNumber, _ := vcrT.matchTrackWith(cassette, copiedReq, true, true, exhausted)
+			if trackNumber != trackNotFound && vcrT.checkReplayOrder(cassette, trackNumber, copiedReq) == nil {
+				if resp = vcrT.rateLimitedResponse(copiedReq); resp == nil {
+					resp = vcrT.replayTrack(cassette, trackNumber, copiedReq)
+					replayed = cassette
+				}
+			}
+		}
+		vcrT.mu.Unlock()
+
+		if replayed != nil {
+			resp = vcrT.filterReplayedResponse(replayed, resp, copiedReq)
+		}
+
+		if resp == nil {
+			vcrT.PCB.Logger.Printf("INFO - Cassette '%s' - No matching track for %s %s\n", vcrT.Cassette.Name, r.Method, r.URL.String())
+			http.NotFound(w, r)
+			return
+		}
+		if resp.StatusCode == 0 {
+			// the track recorded a transport error rather than a response
+			http.Error(w, "govcr: the recorded track holds an error", http.StatusBadGateway)
+			return
+		}
+		if vcrT.PCB.SynthesizeRangeResponses {
+			resp = synthesizeRangeResponse(resp, r.Header)
+		}
+
+		writeResponse(w, resp)
+	})
+}
+
+// preflightResponse returns the response to the request when it is a CORS preflight request that
+// VCRConfig.AutoRespondPreflight responds to, nil otherwise.
+func (pcbr *pcb) preflightResponse(req *http.Request) *http.Response {
+	if !pcbr.AutoRespondPreflight || req.Method != http.MethodOptions || req.Header.Get("Access-Control-Request-Method") == "" {
+		return nil
+	}
+
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		origin = "*"
+	}
+
+	header := http.Header{}
+	header.Set("Access-Control-Allow-Origin", origin)
+	header.Set("Access-Control-Allow-Methods", req.Header.Get("Access-Control-Request-Method"))
+	if h := req.Header.Get("Access-Control-Request-Headers"); h != "" {
+		header.Set("Access-Control-Allow-Headers", h)
+	}
+	if origin != "*" {
+		header.Set("Access-Control-Allow-Credentials", "true")
+		header.Set("Vary", "Origin")
+	}
+	header.Set("Access-Control-Max-Age", "86400")
+
+	for k, val := range pcbr.PreflightHeaders {
+		header[http.CanonicalHeaderKey(k)] = append([]string(nil), val...)
+	}
+
+	return &http.Response{
+		Status:     "204 No Content",
+		StatusCode: http.StatusNoContent,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     header,
+		Body:       http.NoBody,
+		Request:    req,
+	}
+}
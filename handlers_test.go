@@ -0,0 +1,201 @@
+package govcr_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/seborama/govcr"
+)
+
+func TestProxyHandler(t *testing.T) {
+	cassetteName := "TestProxyHandler"
+	clientNum := 1
+
+	// create a test server
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "Hello, client %d", clientNum)
+		clientNum++
+	}))
+	targetURL := ts.URL + "/proxied"
+
+	if err := govcr.DeleteCassette(cassetteName, ""); err != nil {
+		t.Fatalf("err from govcr.DeleteCassette(): Expected nil, got %s", err)
+	}
+
+	// record through the proxy
+	vcr := govcr.NewVCR(cassetteName, &govcr.VCRConfig{Client: &http.Client{Transport: &http.Transport{}}})
+	proxy := httptest.NewServer(vcr.ProxyHandler())
+	defer proxy.Close()
+
+	proxyURL, err := url.Parse(proxy.URL)
+	if err != nil {
+		t.Fatalf("err from url.Parse(): Expected nil, got %s", err)
+	}
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+	resp, err := client.Get(targetURL)
+	if err != nil {
+		t.Fatalf("err from client.Get(): Expected nil, got %s", err)
+	}
+	checkResponseForTestPlaybackOrder(t, resp, "Hello, client 1")
+	checkStats(t, vcr.Stats(), 0, 1, 0)
+
+	// the live server is no longer needed: the cassette is replayed by the VCR client
+	ts.Close()
+
+	vcr = govcr.NewVCR(cassetteName, &govcr.VCRConfig{Client: &http.Client{Transport: &http.Transport{}}})
+	req, err := http.NewRequest("GET", targetURL, nil)
+	if err != nil {
+		t.Fatalf("err from http.NewRequest(): Expected nil, got %s", err)
+	}
+	// the proxied request carried the User-Agent of the original client
+	req.Header.Set("User-Agent", "Go-http-client/1.1")
+
+	resp, err = vcr.Client.Do(req)
+	if err != nil {
+		t.Fatalf("err from vcr.Client.Do(): Expected nil, got %s", err)
+	}
+	checkResponseForTestPlaybackOrder(t, resp, "Hello, client 1")
+	checkStats(t, vcr.Stats(), 1, 0, 1)
+}
+
+func TestServerHandler(t *testing.T) {
+	cassetteName := "TestServerHandler"
+
+	// create a test server
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintf(w, "Hello from %s", r.URL.Path)
+	}))
+
+	if err := govcr.DeleteCassette(cassetteName, ""); err != nil {
+		t.Fatalf("err from govcr.DeleteCassette(): Expected nil, got %s", err)
+	}
+
+	// record
+	vcr := govcr.NewVCR(cassetteName, &govcr.VCRConfig{Client: &http.Client{Transport: &http.Transport{}}})
+	req, err := http.NewRequest("GET", ts.URL+"/hello", nil)
+	if err != nil {
+		t.Fatalf("err from http.NewRequest(): Expected nil, got %s", err)
+	}
+	req.Header.Set("User-Agent", "Go-http-client/1.1")
+	resp, err := vcr.Client.Do(req)
+	if err != nil {
+		t.Fatalf("err from vcr.Client.Do(): Expected nil, got %s", err)
+	}
+	checkResponseForTestPlaybackOrder(t, resp, "Hello from /hello")
+	ts.Close()
+
+	// serve the cassette
+	vcr = govcr.NewVCR(cassetteName, nil)
+	mock := httptest.NewServer(vcr.ServerHandler())
+	defer mock.Close()
+
+	resp, err = http.Get(mock.URL + "/hello")
+	if err != nil {
+		t.Fatalf("err from http.Get(): Expected nil, got %s", err)
+	}
+	checkResponseForTestPlaybackOrder(t, resp, "Hello from /hello")
+	if resp.Header.Get("Content-Type") != "text/plain" {
+		t.Fatalf("Content-Type: expected 'text/plain', got '%s'", resp.Header.Get("Content-Type"))
+	}
+	checkStats(t, vcr.Stats(), 1, 0, 1)
+
+	// the track has been played and there is no other: it is repeated
+	resp, err = http.Get(mock.URL + "/hello")
+	if err != nil {
+		t.Fatalf("err from http.Get(): Expected nil, got %s", err)
+	}
+	checkResponseForTestPlaybackOrder(t, resp, "Hello from /hello")
+	checkStats(t, vcr.Stats(), 1, 0, 1)
+
+	// no track matches
+	resp, err = http.Get(mock.URL + "/other")
+	if err != nil {
+		t.Fatalf("err from http.Get(): Expected nil, got %s", err)
+	}
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("resp.StatusCode: Expected %d, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+
+	// the track is not repeated with ExhaustedTracksError
+	vcr = govcr.NewVCR(cassetteName, &govcr.VCRConfig{ExhaustedTracks: govcr.ExhaustedTracksError})
+	strict := httptest.NewServer(vcr.ServerHandler())
+	defer strict.Close()
+
+	for i, expected := range []int{http.StatusOK, http.StatusNotFound} {
+		resp, err = http.Get(strict.URL + "/hello")
+		if err != nil {
+			t.Fatalf("err from http.Get(): Expected nil, got %s", err)
+		}
+		if resp.StatusCode != expected {
+			t.Fatalf("request #%d: resp.StatusCode: Expected %d, got %d", i, expected, resp.StatusCode)
+		}
+	}
+}
+
+func TestAutoRespondPreflight(t *testing.T) {
+	cassetteName := "TestAutoRespondPreflight"
+
+	// create a test server that must not be called
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("Unexpected live call: %s %s", r.Method, r.URL)
+	}))
+	defer ts.Close()
+
+	if err := govcr.DeleteCassette(cassetteName, ""); err != nil {
+		t.Fatalf("err from govcr.DeleteCassette(): Expected nil, got %s", err)
+	}
+
+	vcr := govcr.NewVCR(cassetteName, &govcr.VCRConfig{
+		AutoRespondPreflight: true,
+		PreflightHeaders:     http.Header{"Access-Control-Max-Age": {"60"}},
+	})
+
+	preflight := func(client *http.Client, url string) *http.Response {
+		req, err := http.NewRequest(http.MethodOptions, url, nil)
+		if err != nil {
+			t.Fatalf("err from http.NewRequest(): Expected nil, got %s", err)
+		}
+		req.Header.Set("Origin", "http://app.example.com")
+		req.Header.Set("Access-Control-Request-Method", "PUT")
+		req.Header.Set("Access-Control-Request-Headers", "Content-Type, X-Token")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("err from client.Do(): Expected nil, got %s", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	checkPreflight := func(resp *http.Response) {
+		if resp.StatusCode != http.StatusNoContent {
+			t.Fatalf("resp.StatusCode: Expected %d, got %d", http.StatusNoContent, resp.StatusCode)
+		}
+		expected := map[string]string{
+			"Access-Control-Allow-Origin":  "http://app.example.com",
+			"Access-Control-Allow-Methods": "PUT",
+			"Access-Control-Allow-Headers": "Content-Type, X-Token",
+			"Access-Control-Max-Age":       "60",
+		}
+		for k, v := range expected {
+			if resp.Header.Get(k) != v {
+				t.Errorf("%s: expected '%s', got '%s'", k, v, resp.Header.Get(k))
+			}
+		}
+	}
+
+	checkPreflight(preflight(vcr.Client, ts.URL))
+	checkStats(t, vcr.Stats(), 0, 0, 0)
+	if vcr.LiveCallCount() != 0 {
+		t.Fatalf("Expected no live call, got %d", vcr.LiveCallCount())
+	}
+
+	// as a mock server
+	mock := httptest.NewServer(vcr.ServerHandler())
+	defer mock.Close()
+	checkPreflight(preflight(http.DefaultClient, mock.URL))
+}
@@ -0,0 +1,161 @@
+package govcr
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// jsonPathResembles indicates whether the values found at each of the JSONPath expressions are
+// equal in both JSON bodies. A body that is not JSON or in which a path is missing never resembles.
+func jsonPathResembles(paths []string, body1, body2 []byte) bool {
+	var doc1, doc2 interface{}
+	if decodeJSON(body1, &doc1) != nil || decodeJSON(body2, &doc2) != nil {
+		return false
+	}
+
+	for _, path := range paths {
+		v1, ok1 := jsonPathValue(doc1, path)
+		v2, ok2 := jsonPathValue(doc2, path)
+		if !ok1 || !ok2 || !reflect.DeepEqual(v1, v2) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// jsonPathValue returns the value found at path in a decoded JSON document.
+// See parseJSONPath for the supported expressions.
+func jsonPathValue(doc interface{}, path string) (interface{}, bool) {
+	segments, ok := parseJSONPath(path)
+	if !ok {
+		return nil, false
+	}
+
+	v := doc
+	for _, segment := range segments {
+		switch s := segment.(type) {
+		case string:
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			if v, ok = m[s]; !ok {
+				return nil, false
+			}
+
+		case int:
+			a, ok := v.([]interface{})
+			if !ok || s < 0 || s >= len(a) {
+				return nil, false
+			}
+			v = a[s]
+		}
+	}
+
+	return v, true
+}
+
+// setJSONPathValue sets the value found at path in a decoded JSON document and returns the
+// updated document. When create is true, the missing members of the path are created (but not
+// the missing array elements). ok is false when the path cannot be set.
+func setJSONPathValue(doc interface{}, path string, value interface{}, create bool) (newDoc interface{}, ok bool) {
+	segments, ok := parseJSONPath(path)
+	if !ok {
+		return doc, false
+	}
+
+	return setJSONValue(doc, segments, value, create)
+}
+
+// setJSONValue sets the value found at the path segments of v. See setJSONPathValue.
+func setJSONValue(v interface{}, segments []interface{}, value interface{}, create bool) (interface{}, bool) {
+	if len(segments) == 0 {
+		return value, true
+	}
+
+	switch s := segments[0].(type) {
+	case string:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			if v != nil || !create {
+				return v, false
+			}
+			m = map[string]interface{}{}
+		}
+		child, found := m[s]
+		if !found && !create {
+			return v, false
+		}
+		child, ok = setJSONValue(child, segments[1:], value, create)
+		if !ok {
+			return v, false
+		}
+		m[s] = child
+		return m, true
+
+	case int:
+		a, ok := v.([]interface{})
+		if !ok || s < 0 || s >= len(a) {
+			return v, false
+		}
+		child, ok := setJSONValue(a[s], segments[1:], value, create)
+		if !ok {
+			return v, false
+		}
+		a[s] = child
+		return a, true
+	}
+
+	return v, false
+}
+
+// parseJSONPath returns the segments of a JSONPath expression: the member names (string) and
+// array indices (int). The supported expressions are made of the root "$" followed by member
+// names (".name" or "['name']") and array indices ("[0]"), i.e. "$.resource.items[0].id".
+func parseJSONPath(path string) ([]interface{}, bool) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, false
+	}
+	path = path[1:]
+
+	var segments []interface{}
+	for path != "" {
+		switch {
+		case strings.HasPrefix(path, "['"):
+			end := strings.Index(path, "']")
+			if end == -1 {
+				return nil, false
+			}
+			segments = append(segments, path[2:end])
+			path = path[end+2:]
+
+		case strings.HasPrefix(path, "["):
+			end := strings.Index(path, "]")
+			if end == -1 {
+				return nil, false
+			}
+			idx, err := strconv.Atoi(path[1:end])
+			if err != nil {
+				return nil, false
+			}
+			segments = append(segments, idx)
+			path = path[end+1:]
+
+		case strings.HasPrefix(path, "."):
+			path = path[1:]
+			end := strings.IndexAny(path, ".[")
+			if end == -1 {
+				end = len(path)
+			}
+			segments = append(segments, path[:end])
+			path = path[end:]
+
+		default:
+			return nil, false
+		}
+	}
+
+	return segments, true
+}
@@ -0,0 +1,176 @@
+package govcr
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// traceMiss logs why the request did not match the closest track of the cassette, that is the
+// first track with the same method and URL, preferably one that has not been replayed.
+// See VCRConfig.MatchTrace.
+func (pcbr *pcb) traceMiss(cassette *Cassette, req *http.Request) {
+	bodyData, err := readRequestBody(req)
+	if err != nil {
+		pcbr.Logger.Println(err)
+		return
+	}
+
+	closest := trackNotFound
+	for idx, track := range cassette.Tracks {
+		if track.Request.Method != req.Method || !pcbr.urlResembles(track.Request.URL, req.URL, false) {
+			continue
+		}
+		if closest == trackNotFound || cassette.Tracks[closest].replayed && !track.replayed {
+			closest = idx
+		}
+	}
+
+	if closest == trackNotFound {
+		pcbr.Logger.Printf("DEBUG - Cassette '%s' - No track with the method and URL of %s %s\n", cassette.Name, req.Method, req.URL.String())
+		return
+	}
+
+	idx, track := closest, cassette.Tracks[closest]
+
+	filteredTrackHeader, filteredTrackBody := pcbr.RequestFilterFunc(track.Request.Header, track.Request.Body)
+	filteredReqHeader, filteredReqBody := pcbr.RequestFilterFunc(req.Header, bodyData)
+
+	trackBody := pcbr.canonicalBody(*filteredReqHeader, *filteredTrackBody)
+	reqBody := pcbr.canonicalBody(*filteredReqHeader, *filteredReqBody)
+
+	var reasons []string
+	if track.replayed {
+		reasons = append(reasons, "the track has already been replayed")
+	}
+	if !pcbr.headerResembles(pcbr.normaliseHeader(*filteredTrackHeader), pcbr.normaliseHeader(*filteredReqHeader)) {
+		reasons = append(reasons, "the headers differ")
+	}
+	if pcbr.shouldMatchBody(req) && !pcbr.trackBodyResembles(track, trackBody, reqBody) {
+		if track.Request.BodyHash != "" {
+			reasons = append(reasons, "the body hashes differ")
+		} else {
+			reasons = append(reasons, "the bodies differ (- track, + request):\n"+bodyDiff(*filteredTrackBody, *filteredReqBody))
+		}
+	}
+
+	pcbr.Logger.Printf("DEBUG - Cassette '%s' - %s %s does not match track #%d: %s\n", cassette.Name, req.Method, req.URL.String(), idx, strings.Join(reasons, "; "))
+}
+
+// bodyDiff returns a readable difference between two bodies: the keys added, removed and changed
+// when both are JSON, or the lines added and removed otherwise.
+func bodyDiff(body1, body2 []byte) string {
+	var json1, json2 interface{}
+	if decodeJSON(body1, &json1) == nil && decodeJSON(body2, &json2) == nil {
+		var lines []string
+		jsonDiff("$", json1, json2, &lines)
+		return strings.Join(lines, "\n")
+	}
+
+	return lineDiff(strings.Split(string(body1), "\n"), strings.Split(string(body2), "\n"))
+}
+
+// decodeJSON decodes a JSON document, keeping numbers as they were written.
+func decodeJSON(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// jsonDiff appends to lines the differences between two decoded JSON values found at path.
+func jsonDiff(path string, v1, v2 interface{}, lines *[]string) {
+	m1, ok1 := v1.(map[string]interface{})
+	m2, ok2 := v2.(map[string]interface{})
+	if ok1 && ok2 {
+		keys := make([]string, 0, len(m1)+len(m2))
+		for k := range m1 {
+			keys = append(keys, k)
+		}
+		for k := range m2 {
+			if _, ok := m1[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			e1, in1 := m1[k]
+			e2, in2 := m2[k]
+			switch {
+			case !in2:
+				*lines = append(*lines, fmt.Sprintf("- %s.%s: %s", path, k, jsonString(e1)))
+			case !in1:
+				*lines = append(*lines, fmt.Sprintf("+ %s.%s: %s", path, k, jsonString(e2)))
+			default:
+				jsonDiff(path+"."+k, e1, e2, lines)
+			}
+		}
+		return
+	}
+
+	a1, ok1 := v1.([]interface{})
+	a2, ok2 := v2.([]interface{})
+	if ok1 && ok2 && len(a1) == len(a2) {
+		for i := range a1 {
+			jsonDiff(fmt.Sprintf("%s[%d]", path, i), a1[i], a2[i], lines)
+		}
+		return
+	}
+
+	if !reflect.DeepEqual(v1, v2) {
+		*lines = append(*lines, fmt.Sprintf("~ %s: %s -> %s", path, jsonString(v1), jsonString(v2)))
+	}
+}
+
+// jsonString returns the JSON encoding of v.
+func jsonString(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+
+	return string(data)
+}
+
+// lineDiff returns the lines removed from lines1 ("- ") and added to lines2 ("+ "), based on
+// their longest common subsequence.
+func lineDiff(lines1, lines2 []string) string {
+	// lcs[i][j] is the length of the longest common subsequence of lines1[i:] and lines2[j:]
+	lcs := make([][]int, len(lines1)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(lines2)+1)
+	}
+	for i := len(lines1) - 1; i >= 0; i-- {
+		for j := len(lines2) - 1; j >= 0; j-- {
+			if lines1[i] == lines2[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var diff []string
+	i, j := 0, 0
+	for i < len(lines1) || j < len(lines2) {
+		switch {
+		case i < len(lines1) && j < len(lines2) && lines1[i] == lines2[j]:
+			i++
+			j++
+		case j == len(lines2) || (i < len(lines1) && lcs[i+1][j] >= lcs[i][j+1]):
+			diff = append(diff, "- "+lines1[i])
+			i++
+		default:
+			diff = append(diff, "+ "+lines2[j])
+			j++
+		}
+	}
+
+	return strings.Join(diff, "\n")
+}
@@ -0,0 +1,39 @@
+package govcr
+
+import "net/http"
+
+// Observer observes the requests made through the VCR, whether they are played back or executed
+// live, i.e. to integrate with a tracing library. See VCRConfig.Observer.
+type Observer interface {
+	// StartRequest is called when the VCR receives a request, before it is matched.
+	// The function returned, unless nil, is called once the request has completed with the
+	// response obtained, whether it was played back from the cassette and the error returned by
+	// the VCR, if any. The response is empty when err is not nil.
+	StartRequest(req Request) func(resp Response, replayed bool, err error)
+}
+
+// observedRoundTrip is RoundTrip for a VCR with an Observer.
+func (t *vcrTransport) observedRoundTrip(req *http.Request) (*http.Response, error) {
+	k7Request, err := newRequest(req)
+	if err != nil {
+		t.PCB.Logger.Println(err)
+		return nil, err
+	}
+
+	done := t.PCB.Observer.StartRequest(k7Request)
+
+	resp, replayed, err := t.roundTrip(req)
+
+	if done != nil {
+		var k7Response Response
+		if err == nil {
+			if k7Response, err = newResponse(resp); err != nil {
+				t.PCB.Logger.Println(err)
+				resp = nil
+			}
+		}
+		done(k7Response, replayed, err)
+	}
+
+	return resp, err
+}
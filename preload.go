@@ -0,0 +1,128 @@
+package govcr
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// PreloadError is the error returned by PreloadCassettes. It holds the error of each cassette
+// that could not be loaded.
+type PreloadError struct {
+	// Errors is the error of each cassette, by name.
+	Errors map[string]error
+}
+
+// Error implements the error interface.
+func (e *PreloadError) Error() string {
+	names := make([]string, 0, len(e.Errors))
+	for name := range e.Errors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	msgs := make([]string, len(names))
+	for i, name := range names {
+		msgs[i] = fmt.Sprintf("%s: %s", name, e.Errors[name])
+	}
+
+	return fmt.Sprintf("govcr: %d cassette(s) failed to load:\n\t%s", len(names), strings.Join(msgs, "\n\t"))
+}
+
+// PreloadCassettes loads all of the cassettes found under dir, including those in
+// sub-directories, i.e. at the start up of a server that replays many cassettes to fail fast on
+// a corrupt one rather than deep into a test run.
+// The cassettes are looked for in the format of vcrConfig (VCRConfig.Format and
+// VCRConfig.OneFilePerTrack), which may be nil, and are read as NewVCR reads them. Their tracks
+// are then checked as with ValidateCassette.
+//
+// The cassettes are returned by name. When some cassettes cannot be loaded or are invalid, the
+// others are returned all the same, with a *PreloadError that holds the error of each failed
+// cassette.
+func PreloadCassettes(dir string, vcrConfig *VCRConfig) (map[string]*Cassette, error) {
+	if vcrConfig == nil {
+		vcrConfig = &VCRConfig{}
+	}
+	vcrConfig = withDefaultConfig(vcrConfig)
+
+	if dir == "" {
+		dir = defaultCassettePath
+	}
+
+	format := vcrConfig.Format
+	if vcrConfig.OneFilePerTrack {
+		format = formatTrackFiles
+	}
+
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	err = filepath.Walk(root, func(filename string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if filename == root && os.IsNotExist(err) {
+				// no cassette has been recorded yet
+				return nil
+			}
+			return err
+		}
+
+		var name string
+		switch {
+		case format == formatTrackFiles:
+			if !fi.IsDir() || filename == root {
+				return nil
+			}
+			if _, err := os.Stat(filepath.Join(filename, trackFilesHeader)); err != nil {
+				return nil
+			}
+			name = filename
+		case fi.IsDir() || !strings.HasSuffix(filename, format.extension()):
+			return nil
+		default:
+			name = strings.TrimSuffix(filename, format.extension())
+		}
+
+		rel, err := filepath.Rel(root, name)
+		if err != nil {
+			return err
+		}
+		names = append(names, filepath.ToSlash(rel))
+
+		if fi.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	cassettes := map[string]*Cassette{}
+	pErr := &PreloadError{Errors: map[string]error{}}
+	for _, name := range names {
+		k7, err := loadCassette(name, dir, true, format)
+		if err != nil {
+			pErr.Errors[name] = err
+			continue
+		}
+
+		if problems := validateTracks(k7); len(problems) > 0 {
+			pErr.Errors[name] = &ValidationError{Cassette: name, Problems: problems}
+			continue
+		}
+
+		k7.tempDir = vcrConfig.TempDir
+		cassettes[name] = k7
+	}
+
+	if len(pErr.Errors) > 0 {
+		return cassettes, pErr
+	}
+
+	return cassettes, nil
+}
@@ -0,0 +1,118 @@
+package govcr
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// byteRange is a range of bytes of a body, from start to end inclusive.
+type byteRange struct {
+	start, end int64
+}
+
+// parseByteRange parses the value of a Range header that holds a single byte range (i.e.
+// "bytes=0-99", "bytes=100-" or "bytes=-100") against a body of the supplied size.
+// ok is false when the header cannot be served as a single range, in which case the full body
+// should be returned. satisfiable is false when the range lies beyond the body.
+func parseByteRange(header string, size int64) (r byteRange, satisfiable bool, ok bool) {
+	spec := strings.TrimSpace(header)
+	if !strings.HasPrefix(spec, "bytes=") {
+		return byteRange{}, false, false
+	}
+	spec = strings.TrimSpace(strings.TrimPrefix(spec, "bytes="))
+	if strings.Contains(spec, ",") {
+		// multipart ranges are not synthesised
+		return byteRange{}, false, false
+	}
+
+	idx := strings.Index(spec, "-")
+	if idx == -1 {
+		return byteRange{}, false, false
+	}
+	first, last := strings.TrimSpace(spec[:idx]), strings.TrimSpace(spec[idx+1:])
+
+	if first == "" {
+		// suffix range: the last n bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return byteRange{}, false, false
+		}
+		if n == 0 || size == 0 {
+			return byteRange{}, false, true
+		}
+		if n > size {
+			n = size
+		}
+		return byteRange{start: size - n, end: size - 1}, true, true
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return byteRange{}, false, false
+	}
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return byteRange{}, false, false
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	if start >= size {
+		return byteRange{}, false, true
+	}
+
+	return byteRange{start: start, end: end}, true, true
+}
+
+// synthesizeRangeResponse returns the partial content response (206) to the byte-range request
+// of reqHdr, sliced from the full response resp. A 416 response is returned when the range
+// cannot be satisfied. resp is returned as is when the request is not a range request, when
+// resp is not a full 200 response or when the If-Range condition does not hold.
+// See VCRConfig.SynthesizeRangeResponses.
+func synthesizeRangeResponse(resp *http.Response, reqHdr http.Header) *http.Response {
+	rangeHdr := reqHdr.Get("Range")
+	if resp == nil || resp.StatusCode != http.StatusOK || rangeHdr == "" {
+		return resp
+	}
+	if ifRange := reqHdr.Get("If-Range"); ifRange != "" && ifRange != resp.Header.Get("ETag") && ifRange != resp.Header.Get("Last-Modified") {
+		return resp
+	}
+
+	body, err := readResponseBody(resp)
+	if err != nil {
+		return resp
+	}
+	size := int64(len(body))
+
+	r, satisfiable, ok := parseByteRange(rangeHdr, size)
+	if !ok {
+		return resp
+	}
+
+	partial := *resp
+	partial.Header = cloneHeader(resp.Header)
+	if partial.Header == nil {
+		partial.Header = http.Header{}
+	}
+
+	var slice []byte
+	if satisfiable {
+		slice = body[r.start : r.end+1]
+		partial.StatusCode = http.StatusPartialContent
+		partial.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", r.start, r.end, size))
+	} else {
+		partial.StatusCode = http.StatusRequestedRangeNotSatisfiable
+		partial.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+	}
+	partial.Status = fmt.Sprintf("%d %s", partial.StatusCode, http.StatusText(partial.StatusCode))
+	partial.Header.Set("Content-Length", strconv.Itoa(len(slice)))
+	partial.ContentLength = int64(len(slice))
+	partial.Body = toReadCloser(slice)
+
+	return &partial
+}
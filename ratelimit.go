@@ -0,0 +1,54 @@
+package govcr
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RateLimit simulates the rate limiting of a server on playback. See VCRConfig.RateLimit.
+type RateLimit struct {
+	// Every is the period of the simulated rate limiting: every Every-th request that matches a
+	// track receives a 429 Too Many Requests. The rate limiting is disabled when Every is zero
+	// and every matching request is rate limited when Every is 1.
+	Every int
+
+	// RetryAfter is the delay of the Retry-After header of the 429 responses, in seconds
+	// (rounded up). The header is not set when RetryAfter is zero.
+	RetryAfter time.Duration
+}
+
+// rateLimitedResponse returns a synthesised 429 response when the request, which matches a
+// track, is to be rate limited as per VCRConfig.RateLimit, nil otherwise. The track is then
+// left for a retry of the request. The caller must hold t.mu.
+func (t *vcrTransport) rateLimitedResponse(req *http.Request) *http.Response {
+	if t.PCB.RateLimit.Every <= 0 {
+		return nil
+	}
+
+	t.rateLimitCalls++
+	if t.rateLimitCalls%t.PCB.RateLimit.Every != 0 {
+		return nil
+	}
+
+	t.PCB.Logger.Printf("INFO - Cassette '%s' - Rate limiting %s %s\n", t.Cassette.Name, req.Method, req.URL.String())
+
+	header := http.Header{}
+	if t.PCB.RateLimit.RetryAfter > 0 {
+		header.Set("Retry-After", strconv.Itoa(int(math.Ceil(t.PCB.RateLimit.RetryAfter.Seconds()))))
+	}
+	header.Set("Content-Length", "0")
+
+	return &http.Response{
+		Status:        "429 Too Many Requests",
+		StatusCode:    http.StatusTooManyRequests,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          http.NoBody,
+		ContentLength: 0,
+		Request:       req,
+	}
+}
@@ -0,0 +1,108 @@
+package govcr
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// Timings are the durations of the phases of a live request, as traced by httptrace.
+// The phases that did not take place (i.e. DNS and Connect when a connection is reused) are zero.
+type Timings struct {
+	// DNS is the duration of the DNS lookup.
+	DNS time.Duration `json:",omitempty"`
+
+	// Connect is the duration of the establishment of the TCP connection.
+	Connect time.Duration `json:",omitempty"`
+
+	// TLSHandshake is the duration of the TLS handshake.
+	TLSHandshake time.Duration `json:",omitempty"`
+
+	// FirstByte is the time from the start of the request to the first byte of the response.
+	FirstByte time.Duration `json:",omitempty"`
+}
+
+// liveTrace collects information about the connection of a live request.
+type liveTrace struct {
+	mu         sync.Mutex
+	remoteAddr string
+
+	start, dnsStart, connectStart, tlsStart time.Time
+	timings                                 Timings
+}
+
+// withClientTrace returns a copy of req whose context traces the connection into lt.
+func (lt *liveTrace) withClientTrace(req *http.Request) *http.Request {
+	lt.start = time.Now()
+
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Conn == nil {
+				return
+			}
+
+			lt.mu.Lock()
+			defer lt.mu.Unlock()
+
+			lt.remoteAddr = info.Conn.RemoteAddr().String()
+		},
+		DNSStart: func(httptrace.DNSStartInfo) {
+			lt.mu.Lock()
+			defer lt.mu.Unlock()
+
+			lt.dnsStart = time.Now()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			lt.mu.Lock()
+			defer lt.mu.Unlock()
+
+			lt.timings.DNS = time.Since(lt.dnsStart)
+		},
+		ConnectStart: func(network, addr string) {
+			lt.mu.Lock()
+			defer lt.mu.Unlock()
+
+			lt.connectStart = time.Now()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			lt.mu.Lock()
+			defer lt.mu.Unlock()
+
+			lt.timings.Connect = time.Since(lt.connectStart)
+		},
+		TLSHandshakeStart: func() {
+			lt.mu.Lock()
+			defer lt.mu.Unlock()
+
+			lt.tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			lt.mu.Lock()
+			defer lt.mu.Unlock()
+
+			lt.timings.TLSHandshake = time.Since(lt.tlsStart)
+		},
+		GotFirstResponseByte: func() {
+			lt.mu.Lock()
+			defer lt.mu.Unlock()
+
+			lt.timings.FirstByte = time.Since(lt.start)
+		},
+	}
+
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+}
+
+// applyTo records the traced information on the track.
+func (lt *liveTrace) applyTo(t *Track) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	t.RemoteAddr = lt.remoteAddr
+	if lt.timings != (Timings{}) {
+		timings := lt.timings
+		t.Timings = &timings
+	}
+}
@@ -0,0 +1,207 @@
+package govcr
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// formatTrackFiles stores a cassette as a directory that holds a file per track.
+// See VCRConfig.OneFilePerTrack.
+const formatTrackFiles CassetteFormat = -1
+
+// trackFilesHeader is the name of the file that holds the fields of a cassette other than its
+// tracks, in formatTrackFiles.
+const trackFilesHeader = "_cassette.json"
+
+// trackFileRegexp matches the names of the track files, in formatTrackFiles.
+var trackFileRegexp = regexp.MustCompile(`^[0-9a-f]{16}(-[0-9]+)?\.json$`)
+
+// trackFilesCassette is the content of the trackFilesHeader file.
+type trackFilesCassette struct {
+	Name, Path string
+	Filters    *CassetteFilters `json:",omitempty"`
+	RecordedAt *time.Time       `json:",omitempty"`
+}
+
+// trackFile is the content of a track file.
+type trackFile struct {
+	// Sequence orders the tracks of the cassette. The sequence of a track does not change when
+	// other tracks are added or removed.
+	Sequence int
+
+	Track Track
+}
+
+// readTrackFiles reads a cassette stored as a directory of track files.
+func readTrackFiles(dir string) (*Cassette, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	k7 := &Cassette{}
+	var files []trackFile
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || (name != trackFilesHeader && !trackFileRegexp.MatchString(name)) {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		k7.size += int64(len(data))
+
+		if name == trackFilesHeader {
+			var header trackFilesCassette
+			if err := json.Unmarshal(data, &header); err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			k7.Name, k7.Path, k7.Filters, k7.RecordedAt = header.Name, header.Path, header.Filters, header.RecordedAt
+			continue
+		}
+
+		var tf trackFile
+		if err := json.Unmarshal(data, &tf); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		tf.Track.sequence = tf.Sequence
+		files = append(files, tf)
+	}
+
+	if k7.size == 0 {
+		// the directory holds no cassette
+		return nil, &os.PathError{Op: "open", Path: filepath.Join(dir, trackFilesHeader), Err: os.ErrNotExist}
+	}
+
+	sort.SliceStable(files, func(i, j int) bool { return files[i].Sequence < files[j].Sequence })
+	for _, tf := range files {
+		k7.Tracks = append(k7.Tracks, tf.Track)
+	}
+
+	return k7, nil
+}
+
+// saveTrackFiles writes the cassette as a directory of track files. Only the files whose content
+// changed are written and the files of the tracks that are no longer on the cassette are deleted.
+func (k7 *Cassette) saveTrackFiles() error {
+	dir := k7.filename()
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return err
+	}
+
+	next := 1
+	for _, t := range k7.Tracks {
+		if t.sequence >= next {
+			next = t.sequence + 1
+		}
+	}
+
+	files := map[string][]byte{}
+
+	header, err := marshalIndent(trackFilesCassette{Name: k7.Name, Path: k7.Path, Filters: k7.Filters, RecordedAt: k7.RecordedAt})
+	if err != nil {
+		return err
+	}
+	files[trackFilesHeader] = header
+
+	occurrences := map[string]int{}
+	for i := range k7.Tracks {
+		t := &k7.Tracks[i]
+		if t.sequence == 0 {
+			t.sequence = next
+			next++
+		}
+
+		name := trackFingerprint(t)
+		occurrences[name]++
+		if n := occurrences[name]; n > 1 {
+			name = fmt.Sprintf("%s-%d", name, n)
+		}
+
+		data, err := marshalIndent(trackFile{Sequence: t.sequence, Track: *t})
+		if err != nil {
+			return err
+		}
+		files[name+".json"] = data
+	}
+
+	var size int64
+	for name, data := range files {
+		size += int64(len(data))
+
+		filename := filepath.Join(dir, name)
+		if existing, err := ioutil.ReadFile(filename); err == nil && bytes.Equal(existing, data) {
+			continue
+		}
+		if err := writeFileAtomic(filename, data, k7.tempDir); err != nil {
+			return err
+		}
+	}
+
+	if err := removeTrackFiles(dir, files); err != nil {
+		return err
+	}
+
+	k7.size = size
+
+	return nil
+}
+
+// removeTrackFiles deletes the track files of dir (and the trackFilesHeader file) that are not in
+// keep. dir is deleted too when it is left empty.
+func removeTrackFiles(dir string, keep map[string][]byte) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	remaining := 0
+	for _, entry := range entries {
+		name := entry.Name()
+		if _, ok := keep[name]; ok || entry.IsDir() || (name != trackFilesHeader && !trackFileRegexp.MatchString(name)) {
+			remaining++
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	if remaining == 0 {
+		if err := os.Remove(dir); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// trackFingerprint returns the name of the file of a track, less the extension: a digest of its
+// request.
+func trackFingerprint(t *Track) string {
+	if t.Request.Fingerprint != "" {
+		return t.Request.Fingerprint[:16]
+	}
+
+	url := ""
+	if t.Request.URL != nil {
+		url = t.Request.URL.String()
+	}
+
+	digest := hashBody([]byte(strings.Join([]string{t.Request.Method, url, t.SequenceKey, t.Variant, string(t.Request.Body), t.Request.BodyHash}, "\n")))
+
+	return digest[:16]
+}
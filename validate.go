@@ -0,0 +1,259 @@
+package govcr
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ValidationError is the error returned by ValidateCassette. It lists all of the problems
+// found with the cassette.
+type ValidationError struct {
+	// Cassette is the name of the cassette.
+	Cassette string
+
+	// Problems describes each of the problems found.
+	Problems []string
+}
+
+// Error implements the error interface.
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("govcr: cassette '%s' is invalid:\n\t%s", e.Cassette, strings.Join(e.Problems, "\n\t"))
+}
+
+// ValidateCassette checks that the cassette file is well formed, i.e. after it has been edited
+// by hand:
+//  - the file decodes and, for a JSON cassette file, has no unknown fields
+//  - each track has a request method and URL and, unless it holds an error, a three digit
+//    response status code
+//  - each body decodes as per the Content-Encoding (gzip, deflate) and, for JSON, the
+//    Content-Type of its header
+//
+// The cassette is read in whichever format it is stored: JSON, gob or one file per track. The
+// track files of VCRConfig.OneFilePerTrack are not checked for unknown fields.
+// A *ValidationError that lists all of the problems found is returned when the cassette is invalid.
+// Other errors are returned when the cassette file cannot be read.
+func ValidateCassette(cassetteName, cassettePath string) error {
+	filename := cassetteFilename(cassetteName, cassettePath, formatTrackFiles)
+	if fi, err := os.Stat(filename); err != nil || !fi.IsDir() {
+		format, err := probeCassetteFormat(cassetteName, cassettePath, FormatJSON)
+		if err != nil {
+			return err
+		}
+		filename = cassetteFilename(cassetteName, cassettePath, format)
+	}
+
+	k7, err := readCassetteFile(filename)
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) {
+		return err
+	}
+
+	vErr := &ValidationError{Cassette: cassetteName}
+	if err != nil {
+		vErr.Problems = append(vErr.Problems, err.Error())
+		return vErr
+	}
+
+	if strings.HasSuffix(filename, cassetteExtension) {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+
+		var v interface{}
+		if err := json.Unmarshal(data, &v); err != nil {
+			return err
+		}
+		for _, field := range unknownFields(v, reflect.TypeOf(Cassette{}), "") {
+			vErr.Problems = append(vErr.Problems, "unknown field "+field)
+		}
+	}
+
+	vErr.Problems = append(vErr.Problems, validateTracks(k7)...)
+
+	if len(vErr.Problems) > 0 {
+		return vErr
+	}
+
+	return nil
+}
+
+// unknownFields returns the paths (i.e. "Tracks[0].Response.Stauts") of the fields of the decoded
+// JSON value v that have no counterpart in type t, and that encoding/json thus ignores.
+func unknownFields(v interface{}, t reflect.Type, path string) []string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if reflect.PtrTo(t).Implements(reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()) {
+		return nil
+	}
+
+	var unknown []string
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if t.Kind() != reflect.Map && t.Kind() != reflect.Struct {
+			return nil
+		}
+
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			fieldPath := k
+			if path != "" {
+				fieldPath = path + "." + k
+			}
+
+			if t.Kind() == reflect.Map {
+				unknown = append(unknown, unknownFields(v[k], t.Elem(), fieldPath)...)
+				continue
+			}
+
+			field, ok := jsonField(t, k)
+			if !ok {
+				unknown = append(unknown, fieldPath)
+				continue
+			}
+			unknown = append(unknown, unknownFields(v[k], field.Type, fieldPath)...)
+		}
+
+	case []interface{}:
+		if t.Kind() != reflect.Slice && t.Kind() != reflect.Array {
+			return nil
+		}
+
+		for i, e := range v {
+			unknown = append(unknown, unknownFields(e, t.Elem(), fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	}
+
+	return unknown
+}
+
+// jsonField returns the field of struct type t that encoding/json decodes the key into.
+func jsonField(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				if f, ok := jsonField(embedded, key); ok {
+					return f, true
+				}
+				continue
+			}
+		}
+
+		if field.PkgPath != "" {
+			// unexported
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		if strings.EqualFold(name, key) {
+			return field, true
+		}
+	}
+
+	return reflect.StructField{}, false
+}
+
+// validateTracks returns the problems found with the tracks of the cassette.
+func validateTracks(k7 *Cassette) []string {
+	var problems []string
+
+	for i, track := range k7.Tracks {
+		problem := func(format string, a ...interface{}) {
+			problems = append(problems, fmt.Sprintf("track #%d: ", i)+fmt.Sprintf(format, a...))
+		}
+
+		// with VCRConfig.MatchFingerprintOnly, the request is not recorded
+		if track.Request.Fingerprint == "" {
+			if track.Request.Method == "" {
+				problem("the request has no method")
+			}
+			if track.Request.URL == nil || track.Request.URL.String() == "" {
+				problem("the request has no URL")
+			}
+		}
+		if err := validateBody(track.Request.Header, track.Request.Body); err != nil {
+			problem("the request body: %s", err)
+		}
+
+		if track.ErrType != "" || track.ErrMsg != "" {
+			continue
+		}
+		if track.Response.StatusCode < 100 || track.Response.StatusCode > 999 {
+			problem("the response has an invalid status code %d", track.Response.StatusCode)
+		}
+		if err := validateBody(track.Response.Header, track.Response.Body); err != nil {
+			problem("the response body: %s", err)
+		}
+	}
+
+	return problems
+}
+
+// validateBody checks that the body decodes as declared by the header.
+func validateBody(header http.Header, body []byte) error {
+	if len(body) == 0 {
+		return nil
+	}
+
+	switch encoding := strings.ToLower(strings.TrimSpace(header.Get("Content-Encoding"))); encoding {
+	case "gzip":
+		r, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("not gzip encoded: %s", err)
+		}
+		if body, err = ioutil.ReadAll(r); err != nil {
+			return fmt.Errorf("not gzip encoded: %s", err)
+		}
+	case "deflate":
+		// "deflate" is zlib wrapped, although raw deflate is found in the wild
+		var r io.Reader = flate.NewReader(bytes.NewReader(body))
+		if zr, err := zlib.NewReader(bytes.NewReader(body)); err == nil {
+			r = zr
+		}
+		decoded, err := ioutil.ReadAll(r)
+		if err != nil {
+			return fmt.Errorf("not deflate encoded: %s", err)
+		}
+		body = decoded
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	if mediaType == "application/json" || strings.HasSuffix(mediaType, "+json") {
+		if !json.Valid(body) {
+			return fmt.Errorf("not valid JSON as declared by Content-Type '%s'", header.Get("Content-Type"))
+		}
+	}
+
+	return nil
+}